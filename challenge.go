@@ -5,6 +5,7 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/dtsang7/ASAPP/config"
 	"github.com/dtsang7/ASAPP/controllers"
+	"github.com/dtsang7/ASAPP/linkpreview"
 	"github.com/dtsang7/ASAPP/models"
 	"github.com/gorilla/mux"
 	"github.com/urfave/negroni"
@@ -14,6 +15,9 @@ import (
 	"strings"
 )
 
+// fetcher of the link previews of text messages, tests replace it with a fake
+var previewFetcher linkpreview.Fetcher = linkpreview.NewHTTPFetcher()
+
 func main() {
 	// load config
 	env := strings.ToLower(os.Getenv("ASAPP_ENV"))
@@ -22,33 +26,101 @@ func main() {
 		log.Println("Server fail to start, unable to retrieve config")
 		os.Exit(1)
 	}
-	// connect to data store
-	dao := models.CreateDAO(config.DBDriver, config.DBName)
-	dao.RunMigrations()
+	keys, err := controllers.NewKeyRing(config)
+	if err != nil {
+		log.Println("Server fail to start, invalid jwt keys", err.Error())
+		os.Exit(1)
+	}
+	// connect to data store, the memory store keeps nothing once the server stops
+	var store models.Store
+	if config.DBDriver == "memory" {
+		store = models.NewMemoryStore()
+	} else {
+		dao := models.CreateDAO(config.DBDriver, config.DBName)
+		dao.RunMigrations(models.MigrationsDir)
+		store = dao
+	}
 
 	// Set up router
-	handler := controllers.Handler{DB: dao}
+	uploadDir := config.UploadDir
+	if uploadDir == "" {
+		uploadDir = "uploads"
+	}
+	maxFileSize := config.MaxFileSize
+	if maxFileSize <= 0 {
+		maxFileSize = 25 << 20
+	}
+	fileTypes := config.FileTypes
+	if len(fileTypes) == 0 {
+		fileTypes = []string{"application/pdf", "text/plain", "image/png", "image/jpeg", "image/gif"}
+	}
+	maxPageSize := config.MaxPageSize
+	if maxPageSize <= 0 {
+		maxPageSize = 100
+	}
+	handler := controllers.Handler{
+		DB:          store,
+		Keys:        keys,
+		Broker:      controllers.NewBroker(),
+		Thumbnails:  controllers.NewThumbnailer(store, uploadDir),
+		Previews:    controllers.NewPreviewer(store, previewFetcher),
+		UploadDir:   uploadDir,
+		Files:       controllers.NewFileLimits(maxFileSize, fileTypes),
+		MaxPageSize: maxPageSize,
+	}
 	publicRouter := mux.NewRouter()
 	protectedRouter := mux.NewRouter()
 
 	mw := jwtmiddleware.New(jwtmiddleware.Options{
-		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-			// This should be an actual secret
-			return []byte("secret"), nil
-		},
-		SigningMethod: jwt.SigningMethodHS256,
+		ValidationKeyGetter: keys.ValidationKeyGetter,
+		SigningMethod:       jwt.SigningMethodHS256,
+		Extractor:           jwtmiddleware.FromAuthHeader,
+	})
+	// browsers can't set headers on websocket and EventSource requests, only these accept the
+	// token as a parameter as urls end up in logs and histories
+	streamMW := jwtmiddleware.New(jwtmiddleware.Options{
+		ValidationKeyGetter: keys.ValidationKeyGetter,
+		SigningMethod:       jwt.SigningMethodHS256,
+		Extractor:           jwtmiddleware.FromFirst(jwtmiddleware.FromAuthHeader, jwtmiddleware.FromParameter("access_token")),
 	})
 
 	//public
 	publicRouter.HandleFunc("/check", handler.CheckHandler).Methods("POST")
 	publicRouter.HandleFunc("/users", handler.UserHandler).Methods("POST")
 	publicRouter.HandleFunc("/login", handler.LoginHandler).Methods("POST")
+	publicRouter.HandleFunc("/token/refresh", handler.RefreshHandler).Methods("POST")
 
 	//protected (jwt)
+	protectedRouter.HandleFunc("/logout", handler.LogoutHandler).Methods("POST")
 	protectedRouter.HandleFunc("/messages", handler.SendMessageHandler).Methods("POST")
 	protectedRouter.HandleFunc("/messages", handler.GetMessagesHandler).Methods("GET")
+	protectedRouter.HandleFunc("/messages/search", handler.SearchMessagesHandler).Methods("GET")
+	protectedRouter.HandleFunc("/messages/read", handler.MarkReadHandler).Methods("POST")
+	protectedRouter.HandleFunc("/messages/{msgId:[0-9]+}", handler.EditMessageHandler).Methods("PATCH")
+	protectedRouter.HandleFunc("/messages/{msgId:[0-9]+}", handler.DeleteMessageHandler).Methods("DELETE")
+	protectedRouter.HandleFunc("/messages/{msgId:[0-9]+}/thumbnails/{size:[0-9]+}", handler.GetThumbnailHandler).Methods("GET")
+	protectedRouter.HandleFunc("/messages/{msgId:[0-9]+}/reactions", handler.AddReactionHandler).Methods("POST")
+	protectedRouter.HandleFunc("/messages/{msgId:[0-9]+}/reactions/{emoji}", handler.RemoveReactionHandler).Methods("DELETE")
+	protectedRouter.HandleFunc("/uploads", handler.UploadHandler).Methods("POST")
+	protectedRouter.HandleFunc("/uploads/{uploadId:[0-9a-f]+}", handler.GetUploadHandler).Methods("GET")
+	protectedRouter.HandleFunc("/conversations", handler.GetConversationsHandler).Methods("GET")
+	protectedRouter.HandleFunc("/conversations/{userId:[0-9]+}/messages", handler.GetConversationMessagesHandler).Methods("GET")
+	protectedRouter.HandleFunc("/groups", handler.CreateGroupHandler).Methods("POST")
+	protectedRouter.HandleFunc("/groups/{groupId:[0-9]+}/members", handler.GetGroupMembersHandler).Methods("GET")
+	protectedRouter.HandleFunc("/groups/{groupId:[0-9]+}/members", handler.AddGroupMemberHandler).Methods("POST")
+	protectedRouter.HandleFunc("/groups/{groupId:[0-9]+}/members/{userId:[0-9]+}", handler.RemoveGroupMemberHandler).Methods("DELETE")
+	protectedRouter.HandleFunc("/groups/{groupId:[0-9]+}/messages", handler.SendGroupMessageHandler).Methods("POST")
+	protectedRouter.HandleFunc("/groups/{groupId:[0-9]+}/messages", handler.GetGroupMessagesHandler).Methods("GET")
+
+	//streams (jwt, header or access_token parameter)
+	streamRouter := mux.NewRouter()
+	streamRouter.HandleFunc("/messages/stream", handler.StreamMessagesHandler).Methods("GET")
+	streamRouter.HandleFunc("/ws", handler.WebSocketHandler).Methods("GET")
 
-	an := negroni.New(negroni.HandlerFunc(mw.HandlerWithNext), negroni.Wrap(protectedRouter))
+	sn := negroni.New(negroni.HandlerFunc(streamMW.HandlerWithNext), negroni.HandlerFunc(handler.Authorize), negroni.Wrap(streamRouter))
+	publicRouter.Handle("/messages/stream", sn).Methods("GET")
+	publicRouter.Handle("/ws", sn).Methods("GET")
+	an := negroni.New(negroni.HandlerFunc(mw.HandlerWithNext), negroni.HandlerFunc(handler.Authorize), negroni.Wrap(protectedRouter))
 	publicRouter.PathPrefix("/").Handler(an)
 
 	n := negroni.Classic()
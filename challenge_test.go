@@ -1,15 +1,30 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/dtsang7/ASAPP/config"
+	"github.com/dtsang7/ASAPP/content"
 	"github.com/dtsang7/ASAPP/controllers"
+	"github.com/dtsang7/ASAPP/linkpreview"
+	"github.com/gorilla/websocket"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"io/ioutil"
 	"log"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -21,6 +36,9 @@ const (
 
 var baseUrl string
 
+// link previews of the messages sent in tests, the server never fetches real pages
+var fakeFetcher = linkpreview.NewFake()
+
 func assertEqual(t *testing.T, a interface{}, b interface{}) {
 	if a != b {
 		t.Fatalf("%s != %s", a, b)
@@ -33,18 +51,54 @@ func assertNotEqual(t *testing.T, a interface{}, b interface{}) {
 	}
 }
 
+// payloads of a message content, zero when the content is of another type
+func textOf(c controllers.MessageContent) content.Text {
+	p, _ := c.Payload.(content.Text)
+	return p
+}
+
+func imageOf(c controllers.MessageContent) content.Image {
+	p, _ := c.Payload.(content.Image)
+	return p
+}
+
+func videoOf(c controllers.MessageContent) content.Video {
+	p, _ := c.Payload.(content.Video)
+	return p
+}
+
+func fileOf(c controllers.MessageContent) content.File {
+	p, _ := c.Payload.(content.File)
+	return p
+}
+
+func audioOf(c controllers.MessageContent) content.Audio {
+	p, _ := c.Payload.(content.Audio)
+	return p
+}
+
+func locationOf(c controllers.MessageContent) content.Location {
+	p, _ := c.Payload.(content.Location)
+	return p
+}
+
 func loginHelper(username, password string) (string, error) {
+	loginResp, err := loginResponseHelper(username, password)
+	return loginResp.Token, err
+}
+
+func loginResponseHelper(username, password string) (controllers.LoginResponse, error) {
+	var loginResp controllers.LoginResponse
 	payload := []byte(fmt.Sprintf(`{"username": "%s", "password": "%s"}`, username, password))
 	resp, err := http.Post(baseUrl+"/login", contentType, bytes.NewBuffer(payload))
 	if err != nil {
-		return "", err
+		return loginResp, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return "", errors.New("Fail to login")
+		return loginResp, errors.New("Fail to login")
 	}
-	var loginResp controllers.LoginResponse
 	json.NewDecoder(resp.Body).Decode(&loginResp)
-	return loginResp.Token, nil
+	return loginResp, nil
 }
 
 func createUserHelper(username, password string) (int, error) {
@@ -80,9 +134,13 @@ func TestMain(m *testing.M) {
 	if err := os.Remove("challenge_test.db"); err != nil {
 		log.Println("unable to remove file", err.Error())
 	}
+	if err := os.RemoveAll("test_uploads"); err != nil {
+		log.Println("unable to remove uploads", err.Error())
+	}
 	// for server to load test config
 	os.Setenv("ASAPP_ENV", "test")
 	// starting app in a goroutine
+	previewFetcher = fakeFetcher
 	go main()
 
 	// load test config
@@ -286,7 +344,7 @@ func TestMessages(t *testing.T) {
 	}
 	// Test send video message successfully
 	{
-		payload := []byte(fmt.Sprintf(`{"sender": %d, "recipient": %d, "content":{"type": "video", "source": "youtube", "url": "http://some_video_url"}}`, user1id, user2id))
+		payload := []byte(fmt.Sprintf(`{"sender": %d, "recipient": %d, "content":{"type": "video", "source": "youtube", "url": "https://youtu.be/dQw4w9WgXcQ"}}`, user1id, user2id))
 		req, _ := http.NewRequest("POST", baseUrl+"/messages", bytes.NewBuffer(payload))
 		req.Header.Set("Authorization", bearer1)
 		req.Header.Set("Content-Type", "application/json")
@@ -318,23 +376,25 @@ func TestMessages(t *testing.T) {
 		assertEqual(t, message0.SenderID, user1id)
 		assertEqual(t, message0.RecipientID, user2id)
 		assertEqual(t, message0.Content.Type, "text")
-		assertEqual(t, message0.Content.Text, "test message 1")
+		assertEqual(t, textOf(message0.Content).Text, "test message 1")
 
 		// Check second message
 		message1 := gr.Messages[1]
 		assertEqual(t, message1.SenderID, user1id)
 		assertEqual(t, message1.RecipientID, user2id)
 		assertEqual(t, message1.Content.Type, "image")
-		assertEqual(t, message1.Content.Width, 10)
-		assertEqual(t, message1.Content.Height, 10)
-		assertEqual(t, message1.Content.Url, "http://some_image_url")
+		assertEqual(t, imageOf(message1.Content).Width, 10)
+		assertEqual(t, imageOf(message1.Content).Height, 10)
+		assertEqual(t, imageOf(message1.Content).Url, "http://some_image_url")
 		// Check third message
 		message2 := gr.Messages[2]
 		assertEqual(t, message2.SenderID, user1id)
 		assertEqual(t, message2.RecipientID, user2id)
 		assertEqual(t, message2.Content.Type, "video")
-		assertEqual(t, message2.Content.Source, "youtube")
-		assertEqual(t, message2.Content.Url, "http://some_video_url")
+		assertEqual(t, videoOf(message2.Content).Source, "youtube")
+		assertEqual(t, videoOf(message2.Content).Url, "https://www.youtube.com/watch?v=dQw4w9WgXcQ")
+		assertEqual(t, videoOf(message2.Content).VideoID, "dQw4w9WgXcQ")
+		assertEqual(t, videoOf(message2.Content).EmbedUrl, "https://www.youtube.com/embed/dQw4w9WgXcQ")
 	}
 	// Test get messages for user1 successfully
 	{
@@ -354,7 +414,2017 @@ func TestMessages(t *testing.T) {
 		assertEqual(t, message0.SenderID, user2id)
 		assertEqual(t, message0.RecipientID, user1id)
 		assertEqual(t, message0.Content.Type, "text")
-		assertEqual(t, message0.Content.Text, "test message 2")
+		assertEqual(t, textOf(message0.Content).Text, "test message 2")
+	}
+
+}
+
+// sign a token by hand with the given key from the test config
+func signTokenHelper(kid, secret string, id int) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":  id,
+		"jti": fmt.Sprintf("%s-%d", kid, time.Now().UnixNano()),
+		"typ": "access",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = kid
+	tokenString, _ := token.SignedString([]byte(secret))
+	return tokenString
+}
+
+/*
+Test Scenario:
+1. Login and check the token is signed with the active key
+2. Check tokens signed with an older, non retired key are accepted
+3. Check tokens signed with a retired or unknown key are rejected
+*/
+func TestKeyRotation(t *testing.T) {
+	username := "test_rotation"
+	password := "test_password"
+	userID, err := createUserHelper(username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.GetConfig("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]string)
+	for _, key := range conf.JWTKeys {
+		keys[key.Kid] = key.Secret
+	}
+	client := &http.Client{}
+	getMessages := func(token string) int {
+		req, _ := http.NewRequest("GET", fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=1", userID), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	// Test login token uses the active key
+	{
+		token, err := loginHelper(username, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, parsed.Header["kid"], conf.JWTActiveKid)
+		assertEqual(t, getMessages(token), http.StatusOK)
+	}
+
+	// Test token signed with previous key is accepted
+	{
+		assertEqual(t, getMessages(signTokenHelper("test-1", keys["test-1"], userID)), http.StatusOK)
+	}
+
+	// Test token signed with retired key is rejected
+	{
+		assertEqual(t, getMessages(signTokenHelper("test-0", keys["test-0"], userID)), http.StatusUnauthorized)
+	}
+
+	// Test token with unknown kid is rejected
+	{
+		assertEqual(t, getMessages(signTokenHelper("unknown", keys["test-2"], userID)), http.StatusUnauthorized)
+	}
+}
+
+/*
+Test Scenario:
+1. Login and exchange the refresh token for a new token pair
+2. Check the used refresh token can't be reused and access tokens can't refresh
+3. Exchange one refresh token concurrently, only one exchange gets a new pair
+4. Logout and check both the access and refresh tokens are revoked
+*/
+func TestRefreshAndLogout(t *testing.T) {
+	username := "test_refresh"
+	password := "test_password"
+	userID, err := createUserHelper(username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, err := loginResponseHelper(username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNotEqual(t, login.RefreshToken, "")
+	client := &http.Client{}
+	refresh := func(refreshToken string) (controllers.LoginResponse, int) {
+		var refreshResp controllers.LoginResponse
+		payload := []byte(fmt.Sprintf(`{"refresh_token": "%s"}`, refreshToken))
+		resp, err := http.Post(baseUrl+"/token/refresh", contentType, bytes.NewBuffer(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&refreshResp)
+		return refreshResp, resp.StatusCode
+	}
+	getMessages := func(token string) int {
+		req, _ := http.NewRequest("GET", fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=1", userID), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	// Test refresh successfully
+	refreshed, status := refresh(login.RefreshToken)
+	assertEqual(t, status, http.StatusOK)
+	assertEqual(t, refreshed.Id, userID)
+	assertNotEqual(t, refreshed.Token, "")
+	assertNotEqual(t, refreshed.RefreshToken, login.RefreshToken)
+	assertEqual(t, getMessages(refreshed.Token), http.StatusOK)
+
+	// Test used refresh token is rejected
+	{
+		_, status := refresh(login.RefreshToken)
+		assertEqual(t, status, http.StatusUnauthorized)
+	}
+
+	// Test access token can't be used to refresh
+	{
+		_, status := refresh(refreshed.Token)
+		assertEqual(t, status, http.StatusUnauthorized)
+	}
+
+	// Test refresh token can't be used as access token
+	{
+		assertEqual(t, getMessages(refreshed.RefreshToken), http.StatusUnauthorized)
+	}
+
+	// Test concurrent exchanges of a refresh token
+	{
+		replayed, err := loginResponseHelper(username, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		const replays = 5
+		statuses := make(chan int, replays)
+		payload := fmt.Sprintf(`{"refresh_token": "%s"}`, replayed.RefreshToken)
+		for i := 0; i < replays; i++ {
+			go func() {
+				resp, err := http.Post(baseUrl+"/token/refresh", contentType, bytes.NewBufferString(payload))
+				if err != nil {
+					statuses <- 0
+					return
+				}
+				resp.Body.Close()
+				statuses <- resp.StatusCode
+			}()
+		}
+		refreshedCount := 0
+		for i := 0; i < replays; i++ {
+			switch status := <-statuses; status {
+			case http.StatusOK:
+				refreshedCount++
+			case http.StatusUnauthorized:
+			default:
+				t.Fatalf("unexpected refresh status %d", status)
+			}
+		}
+		assertEqual(t, refreshedCount, 1)
+	}
+
+	// Test logout revokes access and refresh tokens
+	{
+		payload := []byte(fmt.Sprintf(`{"refresh_token": "%s"}`, refreshed.RefreshToken))
+		req, _ := http.NewRequest("POST", baseUrl+"/logout", bytes.NewBuffer(payload))
+		req.Header.Set("Authorization", "Bearer "+refreshed.Token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, resp.StatusCode, http.StatusNoContent)
+		assertEqual(t, getMessages(refreshed.Token), http.StatusUnauthorized)
+		_, status := refresh(refreshed.RefreshToken)
+		assertEqual(t, status, http.StatusUnauthorized)
+	}
+}
+
+/*
+Test Scenario:
+1. Create three users and login
+2. Check user1 can't send a message pretending to be user2
+3. Check the sender is taken from the token when omitted
+4. Check user3 can't read the messages between user1 and user2
+5. Check the access_token parameter is only accepted by the streaming endpoints
+*/
+func TestAuthorization(t *testing.T) {
+	user1id, _ := createUserHelper("test_authz1", "test_password")
+	user2id, _ := createUserHelper("test_authz2", "test_password")
+	createUserHelper("test_authz3", "test_password")
+	token1, err := loginHelper("test_authz1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token3, err := loginHelper("test_authz3", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{}
+
+	// Test impersonating another sender is rejected
+	{
+		payload := []byte(fmt.Sprintf(`{"sender": %d, "recipient": %d, "content":{"type": "text", "text": "not from user2"}}`, user2id, user1id))
+		req, _ := http.NewRequest("POST", baseUrl+"/messages", bytes.NewBuffer(payload))
+		req.Header.Set("Authorization", "Bearer "+token1)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, resp.StatusCode, http.StatusForbidden)
+	}
+
+	// Test sender is implied by the token
+	{
+		payload := []byte(fmt.Sprintf(`{"recipient": %d, "content":{"type": "text", "text": "from user1"}}`, user2id))
+		req, _ := http.NewRequest("POST", baseUrl+"/messages", bytes.NewBuffer(payload))
+		req.Header.Set("Authorization", "Bearer "+token1)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, resp.StatusCode, http.StatusOK)
+	}
+
+	// Test user3 can't read user2's inbox
+	{
+		req, _ := http.NewRequest("GET", fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=1", user2id), nil)
+		req.Header.Set("Authorization", "Bearer "+token3)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, resp.StatusCode, http.StatusOK)
+		var gr controllers.GetMessagesResponse
+		json.NewDecoder(resp.Body).Decode(&gr)
+		assertEqual(t, len(gr.Messages), 0)
+	}
+
+	// Test user1 reads the message it sent to user2
+	{
+		req, _ := http.NewRequest("GET", fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=1", user2id), nil)
+		req.Header.Set("Authorization", "Bearer "+token1)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gr controllers.GetMessagesResponse
+		json.NewDecoder(resp.Body).Decode(&gr)
+		assertEqual(t, len(gr.Messages), 1)
+		assertEqual(t, gr.Messages[0].SenderID, user1id)
+		assertEqual(t, textOf(gr.Messages[0].Content).Text, "from user1")
+	}
+
+	// Test access_token parameter is rejected outside of the streams
+	{
+		resp, err := client.Get(fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=1&access_token=%s", user2id, token1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, resp.StatusCode, http.StatusUnauthorized)
+
+		streamClient := &http.Client{Timeout: 10 * time.Second}
+		resp, err = streamClient.Get(baseUrl + "/messages/stream?access_token=" + token1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		assertEqual(t, resp.StatusCode, http.StatusOK)
+	}
+}
+
+/*
+Test Scenario:
+1. User1 connects to the websocket endpoint
+2. User2 sends a message to user1 over http, user1 receives it over the socket
+3. User1 sends a message over the socket and gets it back once stored
+4. User1 sends an invalid message and gets an error frame
+*/
+func TestWebSocket(t *testing.T) {
+	user1id, _ := createUserHelper("test_ws1", "test_password")
+	user2id, _ := createUserHelper("test_ws2", "test_password")
+	token1, err := loginHelper("test_ws1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_ws2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test connecting without a token is rejected
+	{
+		_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(baseUrl, "http")+"/ws", nil)
+		assertNotEqual(t, err, nil)
+		assertEqual(t, resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(baseUrl, "http")+"/ws?access_token="+token1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Test receiving a message sent over http
+	{
+		payload := []byte(fmt.Sprintf(`{"recipient": %d, "content":{"type": "text", "text": "pushed message"}}`, user1id))
+		req, _ := http.NewRequest("POST", baseUrl+"/messages", bytes.NewBuffer(payload))
+		req.Header.Set("Authorization", "Bearer "+token2)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sr controllers.SendMessageResponse
+		json.NewDecoder(resp.Body).Decode(&sr)
+
+		var msg controllers.Message
+		err = conn.ReadJSON(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, msg.MsgID, sr.Id)
+		assertEqual(t, msg.TimeStamp, sr.Timestamp)
+		assertEqual(t, msg.SenderID, user2id)
+		assertEqual(t, msg.RecipientID, user1id)
+		assertEqual(t, msg.Content.Type, "text")
+		assertEqual(t, textOf(msg.Content).Text, "pushed message")
+	}
+
+	// Test sending a message over the socket
+	{
+		err := conn.WriteJSON(controllers.Message{RecipientID: user2id, Content: controllers.MessageContent{Type: "text", Payload: content.Text{Text: "socket message"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg controllers.Message
+		err = conn.ReadJSON(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertNotEqual(t, msg.MsgID, 0)
+		assertEqual(t, msg.SenderID, user1id)
+		assertEqual(t, msg.RecipientID, user2id)
+		assertEqual(t, textOf(msg.Content).Text, "socket message")
+	}
+
+	// Test sending an invalid message over the socket
+	{
+		err := conn.WriteJSON(controllers.Message{RecipientID: user2id, Content: controllers.MessageContent{Type: "text"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wsErr controllers.WebSocketError
+		err = conn.ReadJSON(&wsErr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, wsErr.Error, controllers.ErrorMissingArgument)
+	}
+}
+
+// read the next sse event from the stream, skipping heartbeats. The id is empty for edit events
+func readEventHelper(reader *bufio.Reader) (string, string, controllers.Message, error) {
+	var event, id string
+	var msg controllers.Message
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return event, id, msg, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg)
+			if err != nil {
+				return event, id, msg, err
+			}
+		case line == "" && event != "":
+			return event, id, msg, nil
+		}
+	}
+}
+
+/*
+Test Scenario:
+1. User2 sends a message to user1, a message to a group with user1, then another message to user1
+2. User1 opens the stream with Last-Event-ID of the first message and gets the group message
+and the second message replayed
+3. User2 sends another message, user1 receives it live with the same payload as GET /messages
+4. User2 moves a live location it sent to user1 and edits its group message, user1 receives
+both as edit events
+*/
+func TestStreamMessages(t *testing.T) {
+	user1id, _ := createUserHelper("test_sse1", "test_password")
+	createUserHelper("test_sse2", "test_password")
+	token1, err := loginHelper("test_sse1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_sse2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(text string) int {
+		payload := []byte(fmt.Sprintf(`{"recipient": %d, "content":{"type": "text", "text": "%s"}}`, user1id, text))
+		req, _ := http.NewRequest("POST", baseUrl+"/messages", bytes.NewBuffer(payload))
+		req.Header.Set("Authorization", "Bearer "+token2)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sr controllers.SendMessageResponse
+		json.NewDecoder(resp.Body).Decode(&sr)
+		return sr.Id
+	}
+	firstID := send("first")
+	var cg controllers.CreateGroupResponse
+	status := doHelper(t, "POST", token2, baseUrl+"/groups", fmt.Sprintf(`{"name": "sse group", "members": [%d]}`, user1id), &cg)
+	assertEqual(t, status, http.StatusOK)
+	var gs controllers.SendMessageResponse
+	status = doHelper(t, "POST", token2, fmt.Sprintf(baseUrl+"/groups/%d/messages", cg.Id), `{"content":{"type": "text", "text": "to the group"}}`, &gs)
+	assertEqual(t, status, http.StatusOK)
+	secondID := send("second")
+
+	req, _ := http.NewRequest("GET", baseUrl+"/messages/stream", nil)
+	req.Header.Set("Authorization", "Bearer "+token1)
+	req.Header.Set("Last-Event-ID", fmt.Sprint(firstID))
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assertEqual(t, resp.StatusCode, http.StatusOK)
+	assertEqual(t, resp.Header.Get("Content-Type"), "text/event-stream")
+	reader := bufio.NewReader(resp.Body)
+
+	// Test missed messages are replayed, group messages included
+	{
+		event, id, msg, err := readEventHelper(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, event, "message")
+		assertEqual(t, id, fmt.Sprint(gs.Id))
+		assertEqual(t, msg.GroupID, cg.Id)
+		assertEqual(t, textOf(msg.Content).Text, "to the group")
+
+		_, id, msg, err = readEventHelper(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, id, fmt.Sprint(secondID))
+		assertEqual(t, msg.MsgID, secondID)
+		assertEqual(t, textOf(msg.Content).Text, "second")
 	}
 
+	// Test new message is streamed like GET /messages returns it
+	{
+		thirdID := send("third")
+		_, id, msg, err := readEventHelper(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, id, fmt.Sprint(thirdID))
+
+		req, _ := http.NewRequest("GET", fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=%d", user1id, thirdID), nil)
+		req.Header.Set("Authorization", "Bearer "+token1)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gr controllers.GetMessagesResponse
+		json.NewDecoder(resp.Body).Decode(&gr)
+		assertEqual(t, len(gr.Messages), 1)
+		assertEqual(t, gr.Messages[0].MsgID, msg.MsgID)
+		assertEqual(t, gr.Messages[0].TimeStamp, msg.TimeStamp)
+		assertEqual(t, gr.Messages[0].SenderID, msg.SenderID)
+		assertEqual(t, gr.Messages[0].RecipientID, msg.RecipientID)
+		assertEqual(t, gr.Messages[0].Content.Type, msg.Content.Type)
+		assertEqual(t, textOf(gr.Messages[0].Content).Text, textOf(msg.Content).Text)
+		assertEqual(t, textOf(msg.Content).Text, "third")
+	}
+
+	// Test moving a live location is streamed as an edit of the same message
+	{
+		var sr controllers.SendMessageResponse
+		status := doHelper(t, "POST", token2, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "location", "latitude": 1, "longitude": 2, "live_period": 60}}`, user1id), &sr)
+		assertEqual(t, status, http.StatusOK)
+		event, id, _, err := readEventHelper(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, event, "message")
+		assertEqual(t, id, fmt.Sprint(sr.Id))
+
+		status = doHelper(t, "PATCH", token2, fmt.Sprintf(baseUrl+"/messages/%d", sr.Id), `{"content": {"type": "location", "latitude": 3, "longitude": 4}}`, nil)
+		assertEqual(t, status, http.StatusOK)
+		event, id, msg, err := readEventHelper(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, event, "edit")
+		assertEqual(t, id, "")
+		assertEqual(t, msg.MsgID, sr.Id)
+		assertEqual(t, *locationOf(msg.Content).Latitude, 3.0)
+		assertEqual(t, *locationOf(msg.Content).Longitude, 4.0)
+		if msg.EditedAt == "" {
+			t.Fatal("edited message without edited_at")
+		}
+	}
+
+	// Test editing a group message is streamed to the other members
+	{
+		status := doHelper(t, "PATCH", token2, fmt.Sprintf(baseUrl+"/messages/%d", gs.Id), `{"content": {"type": "text", "text": "to the whole group"}}`, nil)
+		assertEqual(t, status, http.StatusOK)
+		event, _, msg, err := readEventHelper(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, event, "edit")
+		assertEqual(t, msg.MsgID, gs.Id)
+		assertEqual(t, textOf(msg.Content).Text, "to the whole group")
+	}
+}
+
+/*
+Test Scenario:
+1. User1 long polls for messages after the last one it received
+2. User2 sends a message, the poll returns it before the wait expires
+3. A poll with nothing new returns an empty list once the wait expires
+*/
+func TestLongPollMessages(t *testing.T) {
+	user1id, _ := createUserHelper("test_poll1", "test_password")
+	createUserHelper("test_poll2", "test_password")
+	token1, err := loginHelper("test_poll1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_poll2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(text string) int {
+		payload := []byte(fmt.Sprintf(`{"recipient": %d, "content":{"type": "text", "text": "%s"}}`, user1id, text))
+		req, _ := http.NewRequest("POST", baseUrl+"/messages", bytes.NewBuffer(payload))
+		req.Header.Set("Authorization", "Bearer "+token2)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sr controllers.SendMessageResponse
+		json.NewDecoder(resp.Body).Decode(&sr)
+		return sr.Id
+	}
+	poll := func(start int, wait string) (controllers.GetMessagesResponse, int) {
+		var gr controllers.GetMessagesResponse
+		req, _ := http.NewRequest("GET", fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=%d&wait=%s", user1id, start, wait), nil)
+		req.Header.Set("Authorization", "Bearer "+token1)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&gr)
+		return gr, resp.StatusCode
+	}
+	lastID := send("before poll")
+
+	// Test invalid wait is rejected
+	{
+		_, status := poll(lastID+1, "soon")
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+
+	// Test poll returns once a message arrives
+	{
+		started := time.Now()
+		results := make(chan controllers.GetMessagesResponse)
+		go func() {
+			gr, _ := poll(lastID+1, "10s")
+			results <- gr
+		}()
+		time.Sleep(200 * time.Millisecond)
+		sentID := send("during poll")
+		gr := <-results
+		assertEqual(t, len(gr.Messages), 1)
+		assertEqual(t, gr.Messages[0].MsgID, sentID)
+		assertEqual(t, textOf(gr.Messages[0].Content).Text, "during poll")
+		if time.Since(started) > 5*time.Second {
+			t.Fatal("poll was not woken by the new message")
+		}
+		lastID = sentID
+	}
+
+	// Test poll times out with no messages
+	{
+		gr, status := poll(lastID+1, "300ms")
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, len(gr.Messages), 0)
+	}
+}
+
+func sendTextHelper(t *testing.T, token string, recipient int, text string) int {
+	payload := []byte(fmt.Sprintf(`{"recipient": %d, "content":{"type": "text", "text": "%s"}}`, recipient, text))
+	req, _ := http.NewRequest("POST", baseUrl+"/messages", bytes.NewBuffer(payload))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, resp.StatusCode, http.StatusOK)
+	var sr controllers.SendMessageResponse
+	json.NewDecoder(resp.Body).Decode(&sr)
+	return sr.Id
+}
+
+// GET url with token and decode the json response into v, returns the status code
+func getHelper(t *testing.T, token string, url string, v interface{}) int {
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	json.NewDecoder(resp.Body).Decode(v)
+	return resp.StatusCode
+}
+
+/*
+Test Scenario:
+1. User2 and user3 send messages to user1, user1 replies to user2
+2. Check user1's conversations are ordered by recency with the last message and unread count
+3. Check the conversation list is paginated
+*/
+func TestConversations(t *testing.T) {
+	user1id, _ := createUserHelper("test_conv1", "test_password")
+	user2id, _ := createUserHelper("test_conv2", "test_password")
+	user3id, _ := createUserHelper("test_conv3", "test_password")
+	token1, err := loginHelper("test_conv1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_conv2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token3, err := loginHelper("test_conv3", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendTextHelper(t, token2, user1id, "from user2")
+	sendTextHelper(t, token3, user1id, "from user3")
+	sendTextHelper(t, token1, user2id, "reply to user2")
+	sendTextHelper(t, token3, user1id, "again from user3")
+
+	// Test conversations ordered by last message
+	{
+		var cr controllers.GetConversationsResponse
+		status := getHelper(t, token1, baseUrl+"/conversations", &cr)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, len(cr.Conversations), 2)
+
+		conversation0 := cr.Conversations[0]
+		assertEqual(t, conversation0.UserID, user3id)
+		assertEqual(t, conversation0.Username, "test_conv3")
+		assertEqual(t, conversation0.LastMessage.Type, "text")
+		assertEqual(t, textOf(conversation0.LastMessage).Text, "again from user3")
+		assertNotEqual(t, conversation0.Timestamp, "")
+		assertEqual(t, conversation0.Unread, 2)
+
+		conversation1 := cr.Conversations[1]
+		assertEqual(t, conversation1.UserID, user2id)
+		assertEqual(t, textOf(conversation1.LastMessage).Text, "reply to user2")
+		assertEqual(t, conversation1.Unread, 1)
+	}
+
+	// Test pagination
+	{
+		var cr controllers.GetConversationsResponse
+		status := getHelper(t, token1, baseUrl+"/conversations?limit=1&offset=1", &cr)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, len(cr.Conversations), 1)
+		assertEqual(t, cr.Conversations[0].UserID, user2id)
+	}
+
+	// Test invalid offset
+	{
+		var cr controllers.GetConversationsResponse
+		status := getHelper(t, token1, baseUrl+"/conversations?offset=-1", &cr)
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+}
+
+/*
+Test Scenario:
+1. User1 and user2 exchange four messages, user3 sends one to user1
+2. Check user1 gets both directions of the conversation with user2 in order
+3. Check the before and after cursors page through the conversation
+*/
+func TestConversationMessages(t *testing.T) {
+	user1id, _ := createUserHelper("test_history1", "test_password")
+	user2id, _ := createUserHelper("test_history2", "test_password")
+	createUserHelper("test_history3", "test_password")
+	token1, err := loginHelper("test_history1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_history2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token3, err := loginHelper("test_history3", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{
+		sendTextHelper(t, token1, user2id, "one"),
+		sendTextHelper(t, token2, user1id, "two"),
+	}
+	sendTextHelper(t, token3, user1id, "not in this conversation")
+	ids = append(ids, sendTextHelper(t, token1, user2id, "three"), sendTextHelper(t, token2, user1id, "four"))
+	historyUrl := fmt.Sprintf(baseUrl+"/conversations/%d/messages", user2id)
+
+	// Test both directions are returned in order
+	{
+		var gr controllers.GetConversationMessagesResponse
+		status := getHelper(t, token1, historyUrl, &gr)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, len(gr.Messages), 4)
+		for i, text := range []string{"one", "two", "three", "four"} {
+			assertEqual(t, gr.Messages[i].MsgID, ids[i])
+			assertEqual(t, textOf(gr.Messages[i].Content).Text, text)
+		}
+		assertEqual(t, gr.Messages[0].SenderID, user1id)
+		assertEqual(t, gr.Messages[1].SenderID, user2id)
+		assertEqual(t, gr.Before, ids[0])
+		assertEqual(t, gr.After, ids[3])
+	}
+
+	// Test latest page then older messages with the before cursor
+	{
+		var gr controllers.GetConversationMessagesResponse
+		getHelper(t, token1, historyUrl+"?limit=2", &gr)
+		assertEqual(t, len(gr.Messages), 2)
+		assertEqual(t, textOf(gr.Messages[0].Content).Text, "three")
+		assertEqual(t, textOf(gr.Messages[1].Content).Text, "four")
+
+		var older controllers.GetConversationMessagesResponse
+		getHelper(t, token1, fmt.Sprintf(historyUrl+"?limit=2&before=%d", gr.Before), &older)
+		assertEqual(t, len(older.Messages), 2)
+		assertEqual(t, textOf(older.Messages[0].Content).Text, "one")
+		assertEqual(t, textOf(older.Messages[1].Content).Text, "two")
+	}
+
+	// Test newer messages with the after cursor
+	{
+		var gr controllers.GetConversationMessagesResponse
+		getHelper(t, token2, fmt.Sprintf(baseUrl+"/conversations/%d/messages?after=%d", user1id, ids[1]), &gr)
+		assertEqual(t, len(gr.Messages), 2)
+		assertEqual(t, textOf(gr.Messages[0].Content).Text, "three")
+		assertEqual(t, textOf(gr.Messages[1].Content).Text, "four")
+	}
+}
+
+/*
+Test Scenario:
+1. User2 sends two messages to user1, neither is delivered or read
+2. User1 fetches its messages, they are returned and stored as delivered
+3. User1 marks the first message read, the sender sees read_at on it only
+*/
+func TestReadReceipts(t *testing.T) {
+	user1id, _ := createUserHelper("test_receipt1", "test_password")
+	user2id, _ := createUserHelper("test_receipt2", "test_password")
+	token1, err := loginHelper("test_receipt1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_receipt2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstID := sendTextHelper(t, token2, user1id, "first")
+	sendTextHelper(t, token2, user1id, "second")
+	historyUrl := fmt.Sprintf(baseUrl+"/conversations/%d/messages", user1id)
+	markRead := func(payload string) (controllers.MarkReadResponse, int) {
+		var mr controllers.MarkReadResponse
+		req, _ := http.NewRequest("POST", baseUrl+"/messages/read", bytes.NewBufferString(payload))
+		req.Header.Set("Authorization", "Bearer "+token1)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&mr)
+		return mr, resp.StatusCode
+	}
+
+	// Test messages start undelivered
+	{
+		var gr controllers.GetConversationMessagesResponse
+		getHelper(t, token2, historyUrl, &gr)
+		assertEqual(t, len(gr.Messages), 2)
+		assertEqual(t, gr.Messages[0].DeliveredAt, "")
+		assertEqual(t, gr.Messages[0].ReadAt, "")
+	}
+
+	// Test fetching by the recipient marks messages delivered
+	{
+		var gr controllers.GetMessagesResponse
+		getHelper(t, token1, fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=%d", user1id, firstID), &gr)
+		assertEqual(t, len(gr.Messages), 2)
+		for _, msg := range gr.Messages {
+			if _, err := time.Parse(time.RFC3339, msg.DeliveredAt); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var history controllers.GetConversationMessagesResponse
+		getHelper(t, token2, historyUrl, &history)
+		assertNotEqual(t, history.Messages[0].DeliveredAt, "")
+		assertNotEqual(t, history.Messages[1].DeliveredAt, "")
+		assertEqual(t, history.Messages[0].ReadAt, "")
+	}
+
+	// Test invalid mark read request
+	{
+		_, status := markRead(`{}`)
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+
+	// Test marking messages read up to a msg_id
+	{
+		mr, status := markRead(fmt.Sprintf(`{"msg_id": %d, "sender": %d}`, firstID, user2id))
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, mr.Updated, 1)
+
+		var history controllers.GetConversationMessagesResponse
+		getHelper(t, token2, historyUrl, &history)
+		assertNotEqual(t, history.Messages[0].ReadAt, "")
+		assertEqual(t, history.Messages[1].ReadAt, "")
+	}
+}
+
+// send a request with a json body and token, decode the json response into v when set
+func doHelper(t *testing.T, method string, token string, url string, payload string, v interface{}) int {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(payload))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		json.NewDecoder(resp.Body).Decode(v)
+	}
+	return resp.StatusCode
+}
+
+/*
+Test Scenario:
+1. User1 creates a group with user2, then user2 adds user3
+2. Members send messages and read the group history, a non member can't
+3. User3 leaves, user2 can't remove user1, the owner can't leave and removes user2
+4. Removed members can no longer read or write the group
+*/
+func TestGroups(t *testing.T) {
+	user1id, _ := createUserHelper("test_group1", "test_password")
+	user2id, _ := createUserHelper("test_group2", "test_password")
+	user3id, _ := createUserHelper("test_group3", "test_password")
+	createUserHelper("test_group4", "test_password")
+	tokens := make(map[int]string)
+	for i := 1; i <= 4; i++ {
+		token, err := loginHelper(fmt.Sprintf("test_group%d", i), "test_password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[i] = token
+	}
+
+	// Test create group without a name
+	{
+		status := doHelper(t, "POST", tokens[1], baseUrl+"/groups", `{"members": []}`, nil)
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+
+	var cg controllers.CreateGroupResponse
+	status := doHelper(t, "POST", tokens[1], baseUrl+"/groups", fmt.Sprintf(`{"name": "test group", "members": [%d]}`, user2id), &cg)
+	assertEqual(t, status, http.StatusOK)
+	assertNotEqual(t, cg.Id, 0)
+	groupUrl := fmt.Sprintf(baseUrl+"/groups/%d", cg.Id)
+
+	// Test members can add members, non members can't
+	{
+		status := doHelper(t, "POST", tokens[4], groupUrl+"/members", fmt.Sprintf(`{"user": %d}`, user3id), nil)
+		assertEqual(t, status, http.StatusForbidden)
+		status = doHelper(t, "POST", tokens[2], groupUrl+"/members", fmt.Sprintf(`{"user": %d}`, user3id), nil)
+		assertEqual(t, status, http.StatusNoContent)
+
+		var gm controllers.GetGroupMembersResponse
+		status = getHelper(t, tokens[3], groupUrl+"/members", &gm)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, len(gm.Members), 3)
+		assertEqual(t, gm.Members[0].UserID, user1id)
+		assertEqual(t, gm.Members[0].Username, "test_group1")
+	}
+
+	// Test members send and read group messages, non members can't
+	{
+		status := doHelper(t, "POST", tokens[1], groupUrl+"/messages", `{"content":{"type": "text", "text": "hello group"}}`, nil)
+		assertEqual(t, status, http.StatusOK)
+		status = doHelper(t, "POST", tokens[3], baseUrl+"/messages", fmt.Sprintf(`{"group": %d, "content":{"type": "text", "text": "hi all"}}`, cg.Id), nil)
+		assertEqual(t, status, http.StatusOK)
+		status = doHelper(t, "POST", tokens[4], groupUrl+"/messages", `{"content":{"type": "text", "text": "let me in"}}`, nil)
+		assertEqual(t, status, http.StatusForbidden)
+
+		var gr controllers.GetMessagesResponse
+		status = getHelper(t, tokens[2], groupUrl+"/messages?start=1", &gr)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, len(gr.Messages), 2)
+		assertEqual(t, gr.Messages[0].SenderID, user1id)
+		assertEqual(t, gr.Messages[0].GroupID, cg.Id)
+		assertEqual(t, gr.Messages[0].RecipientID, 0)
+		assertEqual(t, textOf(gr.Messages[0].Content).Text, "hello group")
+		assertEqual(t, gr.Messages[1].SenderID, user3id)
+
+		status = getHelper(t, tokens[4], groupUrl+"/messages?start=1", &gr)
+		assertEqual(t, status, http.StatusForbidden)
+	}
+
+	// Test leaving and removing members
+	{
+		status := doHelper(t, "DELETE", tokens[3], fmt.Sprintf(groupUrl+"/members/%d", user3id), "", nil)
+		assertEqual(t, status, http.StatusNoContent)
+		status = doHelper(t, "DELETE", tokens[2], fmt.Sprintf(groupUrl+"/members/%d", user1id), "", nil)
+		assertEqual(t, status, http.StatusForbidden)
+		status = doHelper(t, "DELETE", tokens[1], fmt.Sprintf(groupUrl+"/members/%d", user1id), "", nil)
+		assertEqual(t, status, http.StatusForbidden)
+		status = doHelper(t, "DELETE", tokens[1], fmt.Sprintf(groupUrl+"/members/%d", user2id), "", nil)
+		assertEqual(t, status, http.StatusNoContent)
+
+		var gr controllers.GetMessagesResponse
+		status = getHelper(t, tokens[2], groupUrl+"/messages?start=1", &gr)
+		assertEqual(t, status, http.StatusForbidden)
+		status = doHelper(t, "POST", tokens[3], groupUrl+"/messages", `{"content":{"type": "text", "text": "still here?"}}`, nil)
+		assertEqual(t, status, http.StatusForbidden)
+	}
+}
+
+/*
+Test Scenario:
+1. User1 sends a text and an image message to user2
+2. Only the sender can edit, and only the text of text messages
+3. Only the sender can delete, deleted messages are returned as "deleted" placeholders
+4. Deleted messages can't be edited or deleted again, unknown messages are not found
+*/
+func TestEditAndDeleteMessages(t *testing.T) {
+	user1id, _ := createUserHelper("test_edit1", "test_password")
+	user2id, _ := createUserHelper("test_edit2", "test_password")
+	token1, err := loginHelper("test_edit1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_edit2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	textID := sendTextHelper(t, token1, user2id, "typo")
+	var sr controllers.SendMessageResponse
+	doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "image", "width": 10, "height": 10, "url": "http://some_image_url"}}`, user2id), &sr)
+	imageID := sr.Id
+	textUrl := fmt.Sprintf(baseUrl+"/messages/%d", textID)
+	historyUrl := fmt.Sprintf(baseUrl+"/conversations/%d/messages", user1id)
+
+	// Test recipient can't edit
+	{
+		status := doHelper(t, "PATCH", token2, textUrl, `{"content": {"type": "text", "text": "hacked"}}`, nil)
+		assertEqual(t, status, http.StatusForbidden)
+	}
+
+	// Test only text messages can be edited
+	{
+		status := doHelper(t, "PATCH", token1, fmt.Sprintf(baseUrl+"/messages/%d", imageID), `{"content": {"type": "text", "text": "not an image"}}`, nil)
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+
+	// Test sender edits text
+	{
+		var msg controllers.Message
+		status := doHelper(t, "PATCH", token1, textUrl, `{"content": {"type": "text", "text": "fixed"}}`, &msg)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, msg.MsgID, textID)
+		assertEqual(t, textOf(msg.Content).Text, "fixed")
+		assertNotEqual(t, msg.EditedAt, "")
+	}
+
+	// Test recipient can't delete
+	{
+		status := doHelper(t, "DELETE", token2, textUrl, "", nil)
+		assertEqual(t, status, http.StatusForbidden)
+	}
+
+	// Test sender deletes, the message becomes a placeholder
+	{
+		status := doHelper(t, "DELETE", token1, textUrl, "", nil)
+		assertEqual(t, status, http.StatusNoContent)
+
+		var gr controllers.GetConversationMessagesResponse
+		getHelper(t, token2, historyUrl, &gr)
+		assertEqual(t, len(gr.Messages), 2)
+		assertEqual(t, gr.Messages[0].MsgID, textID)
+		assertEqual(t, gr.Messages[0].Content.Type, "deleted")
+		assertEqual(t, textOf(gr.Messages[0].Content).Text, "")
+		assertNotEqual(t, gr.Messages[0].DeletedAt, "")
+		assertEqual(t, gr.Messages[1].Content.Type, "image")
+	}
+
+	// Test deleted messages can't be changed
+	{
+		status := doHelper(t, "PATCH", token1, textUrl, `{"content": {"type": "text", "text": "back"}}`, nil)
+		assertEqual(t, status, http.StatusGone)
+		status = doHelper(t, "DELETE", token1, textUrl, "", nil)
+		assertEqual(t, status, http.StatusGone)
+	}
+
+	// Test unknown message
+	{
+		status := doHelper(t, "DELETE", token1, baseUrl+"/messages/999999", "", nil)
+		assertEqual(t, status, http.StatusNotFound)
+	}
+}
+
+/*
+Test Scenario:
+1. User1 and user2 react to a message, reacting twice with the same emoji counts once
+2. Reactions are aggregated by emoji and marked as reacted by the user fetching them
+3. Reactions can be removed, plain text is not a reaction
+4. Users who can't see the message or deleted messages can't get reactions
+*/
+func TestReactions(t *testing.T) {
+	user1id, _ := createUserHelper("test_react1", "test_password")
+	user2id, _ := createUserHelper("test_react2", "test_password")
+	createUserHelper("test_react3", "test_password")
+	token1, err := loginHelper("test_react1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_react2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token3, err := loginHelper("test_react3", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgID := sendTextHelper(t, token1, user2id, "react to this")
+	reactionsUrl := fmt.Sprintf(baseUrl+"/messages/%d/reactions", msgID)
+	messagesUrl := fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=%d", user2id, msgID)
+
+	// Test both users react, reacting twice counts once
+	{
+		status := doHelper(t, "POST", token1, reactionsUrl, `{"emoji": "👍"}`, nil)
+		assertEqual(t, status, http.StatusNoContent)
+		status = doHelper(t, "POST", token2, reactionsUrl, `{"emoji": "👍"}`, nil)
+		assertEqual(t, status, http.StatusNoContent)
+		status = doHelper(t, "POST", token2, reactionsUrl, `{"emoji": "👍"}`, nil)
+		assertEqual(t, status, http.StatusNoContent)
+		status = doHelper(t, "POST", token2, reactionsUrl, `{"emoji": "❤️"}`, nil)
+		assertEqual(t, status, http.StatusNoContent)
+
+		var gr controllers.GetMessagesResponse
+		getHelper(t, token1, messagesUrl, &gr)
+		assertEqual(t, len(gr.Messages), 1)
+		assertEqual(t, len(gr.Messages[0].Reactions), 2)
+		assertEqual(t, gr.Messages[0].Reactions[0].Emoji, "👍")
+		assertEqual(t, gr.Messages[0].Reactions[0].Count, 2)
+		assertEqual(t, gr.Messages[0].Reactions[0].ReactedByMe, true)
+		assertEqual(t, gr.Messages[0].Reactions[1].Emoji, "❤️")
+		assertEqual(t, gr.Messages[0].Reactions[1].Count, 1)
+		assertEqual(t, gr.Messages[0].Reactions[1].ReactedByMe, false)
+	}
+
+	// Test the conversation history has the same reactions
+	{
+		var gr controllers.GetConversationMessagesResponse
+		getHelper(t, token2, fmt.Sprintf(baseUrl+"/conversations/%d/messages", user1id), &gr)
+		assertEqual(t, len(gr.Messages), 1)
+		assertEqual(t, len(gr.Messages[0].Reactions), 2)
+		assertEqual(t, gr.Messages[0].Reactions[1].ReactedByMe, true)
+	}
+
+	// Test removing a reaction
+	{
+		status := doHelper(t, "DELETE", token1, reactionsUrl+"/"+url.PathEscape("👍"), "", nil)
+		assertEqual(t, status, http.StatusNoContent)
+
+		var gr controllers.GetMessagesResponse
+		getHelper(t, token1, messagesUrl, &gr)
+		assertEqual(t, len(gr.Messages[0].Reactions), 2)
+		for _, reaction := range gr.Messages[0].Reactions {
+			assertEqual(t, reaction.Count, 1)
+			assertEqual(t, reaction.ReactedByMe, false)
+		}
+	}
+
+	// Test plain text is not a reaction
+	{
+		status := doHelper(t, "POST", token1, reactionsUrl, `{"emoji": "lol"}`, nil)
+		assertEqual(t, status, http.StatusBadRequest)
+		status = doHelper(t, "POST", token1, reactionsUrl, `{}`, nil)
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+
+	// Test users outside the conversation can't react
+	{
+		status := doHelper(t, "POST", token3, reactionsUrl, `{"emoji": "👍"}`, nil)
+		assertEqual(t, status, http.StatusNotFound)
+	}
+
+	// Test deleted messages can't get reactions
+	{
+		status := doHelper(t, "DELETE", token1, fmt.Sprintf(baseUrl+"/messages/%d", msgID), "", nil)
+		assertEqual(t, status, http.StatusNoContent)
+		status = doHelper(t, "POST", token2, reactionsUrl, `{"emoji": "😮"}`, nil)
+		assertEqual(t, status, http.StatusGone)
+
+		var gr controllers.GetMessagesResponse
+		getHelper(t, token1, messagesUrl, &gr)
+		assertEqual(t, len(gr.Messages[0].Reactions), 0)
+	}
+}
+
+/*
+Test Scenario:
+1. User2 replies to a message of user1, fetched replies embed a preview of the quoted message
+2. Long quoted texts are cut in the preview
+3. Replies to unknown messages or messages of another conversation are rejected
+4. Quoting a message that is deleted afterwards shows the "deleted" placeholder
+*/
+func TestReplies(t *testing.T) {
+	user1id, _ := createUserHelper("test_reply1", "test_password")
+	user2id, _ := createUserHelper("test_reply2", "test_password")
+	user3id, _ := createUserHelper("test_reply3", "test_password")
+	token1, err := loginHelper("test_reply1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_reply2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	longText := strings.Repeat("a", 150)
+	quotedID := sendTextHelper(t, token1, user2id, "question?")
+	longID := sendTextHelper(t, token1, user2id, longText)
+	otherID := sendTextHelper(t, token1, user3id, "private")
+	historyUrl := fmt.Sprintf(baseUrl+"/conversations/%d/messages", user2id)
+
+	// Test reply embeds the quoted message
+	{
+		var sr controllers.SendMessageResponse
+		status := doHelper(t, "POST", token2, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "reply_to": %d, "content":{"type": "text", "text": "answer"}}`, user1id, quotedID), &sr)
+		assertEqual(t, status, http.StatusOK)
+		doHelper(t, "POST", token2, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "reply_to": %d, "content":{"type": "text", "text": "too long"}}`, user1id, longID), nil)
+
+		var gr controllers.GetMessagesResponse
+		getHelper(t, token1, fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=%d", user1id, sr.Id), &gr)
+		assertEqual(t, len(gr.Messages), 2)
+		assertEqual(t, gr.Messages[0].ReplyTo, quotedID)
+		if gr.Messages[0].Quote == nil {
+			t.Fatal("reply without quote")
+		}
+		assertEqual(t, gr.Messages[0].Quote.MsgID, quotedID)
+		assertEqual(t, gr.Messages[0].Quote.SenderID, user1id)
+		assertEqual(t, textOf(gr.Messages[0].Quote.Content).Text, "question?")
+		assertEqual(t, textOf(gr.Messages[1].Quote.Content).Text, strings.Repeat("a", 100)+"…")
+	}
+
+	// Test replies must quote a visible message of the same conversation
+	{
+		status := doHelper(t, "POST", token2, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "reply_to": 999999, "content":{"type": "text", "text": "what?"}}`, user1id), nil)
+		assertEqual(t, status, http.StatusBadRequest)
+		status = doHelper(t, "POST", token2, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "reply_to": %d, "content":{"type": "text", "text": "what?"}}`, user1id, otherID), nil)
+		assertEqual(t, status, http.StatusBadRequest)
+		status = doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "reply_to": %d, "content":{"type": "text", "text": "what?"}}`, user2id, otherID), nil)
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+
+	// Test quoted message deleted after the reply
+	{
+		status := doHelper(t, "DELETE", token1, fmt.Sprintf(baseUrl+"/messages/%d", quotedID), "", nil)
+		assertEqual(t, status, http.StatusNoContent)
+
+		var gr controllers.GetConversationMessagesResponse
+		getHelper(t, token1, historyUrl, &gr)
+		assertEqual(t, len(gr.Messages), 4)
+		assertEqual(t, gr.Messages[2].ReplyTo, quotedID)
+		assertEqual(t, gr.Messages[2].Quote.Content.Type, "deleted")
+		assertEqual(t, textOf(gr.Messages[2].Quote.Content).Text, "")
+
+		status = doHelper(t, "POST", token2, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "reply_to": %d, "content":{"type": "text", "text": "gone"}}`, user1id, quotedID), nil)
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+}
+
+/*
+Test Scenario:
+1. User1 and user2 exchange messages, user3 sends user1 a message with the same words
+2. A search returns the matching messages the searching user sent or received, newest first, with snippets
+3. Edited and deleted messages are searched by their current text
+4. Search results are paged with limit and offset
+5. Snippets are HTML escaped and cut around the first match
+*/
+func TestSearchMessages(t *testing.T) {
+	user1id, _ := createUserHelper("test_search1", "test_password")
+	user2id, _ := createUserHelper("test_search2", "test_password")
+	user3id, _ := createUserHelper("test_search3", "test_password")
+	token1, err := loginHelper("test_search1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_search2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token3, err := loginHelper("test_search3", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstID := sendTextHelper(t, token1, user2id, "the zebracorn meeting is at noon")
+	secondID := sendTextHelper(t, token2, user1id, "which zebracorn meeting?")
+	sendTextHelper(t, token3, user1id, "not about a zebracorn")
+	typoID := sendTextHelper(t, token1, user2id, "bring the zebracron slides")
+	searchUrl := baseUrl + "/messages/search?q=" + url.QueryEscape("zebracorn meeting")
+
+	// Test search returns matching messages the user participates in, newest first
+	{
+		var sr controllers.SearchMessagesResponse
+		status := getHelper(t, token2, searchUrl, &sr)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, len(sr.Results), 2)
+		assertEqual(t, sr.Results[0].Message.MsgID, secondID)
+		assertEqual(t, sr.Results[0].Snippet, "which <mark>zebracorn</mark> <mark>meeting</mark>?")
+		assertEqual(t, sr.Results[1].Message.MsgID, firstID)
+		assertEqual(t, textOf(sr.Results[1].Message.Content).Text, "the zebracorn meeting is at noon")
+	}
+
+	// Test search input is not interpreted as query syntax
+	{
+		var sr controllers.SearchMessagesResponse
+		status := getHelper(t, token1, baseUrl+"/messages/search?q="+url.QueryEscape(`zebracorn" OR "AND (`), &sr)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, len(sr.Results), 0)
+		status = getHelper(t, token1, baseUrl+"/messages/search?q=", nil)
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+
+	// Test edited and deleted messages
+	{
+		doHelper(t, "PATCH", token1, fmt.Sprintf(baseUrl+"/messages/%d", typoID), `{"content": {"type": "text", "text": "bring the zebracorn meeting slides"}}`, nil)
+		doHelper(t, "DELETE", token1, fmt.Sprintf(baseUrl+"/messages/%d", firstID), "", nil)
+
+		var sr controllers.SearchMessagesResponse
+		getHelper(t, token2, searchUrl, &sr)
+		assertEqual(t, len(sr.Results), 2)
+		assertEqual(t, sr.Results[0].Message.MsgID, typoID)
+		assertEqual(t, sr.Results[1].Message.MsgID, secondID)
+	}
+
+	// Test paging, user1 also sees the message from user3
+	{
+		var sr controllers.SearchMessagesResponse
+		getHelper(t, token1, baseUrl+"/messages/search?q=zebracorn&limit=2&offset=1", &sr)
+		assertEqual(t, len(sr.Results), 2)
+		assertEqual(t, sr.Results[0].Message.SenderID, user3id)
+		assertEqual(t, sr.Results[1].Message.MsgID, secondID)
+	}
+
+	// Test snippets of markup and of long texts
+	{
+		sendTextHelper(t, token1, user2id, "<img src=x onerror='alert(1)'> zebrafox & co")
+		var sr controllers.SearchMessagesResponse
+		getHelper(t, token2, baseUrl+"/messages/search?q=zebrafox", &sr)
+		assertEqual(t, len(sr.Results), 1)
+		assertEqual(t, sr.Results[0].Snippet, "&lt;img src=x onerror=&#39;alert(1)&#39;&gt; <mark>zebrafox</mark> &amp; co")
+
+		words := strings.Repeat("word ", 30)
+		sendTextHelper(t, token1, user2id, words+"zebrabird "+words)
+		sr = controllers.SearchMessagesResponse{}
+		getHelper(t, token2, baseUrl+"/messages/search?q=zebrabird", &sr)
+		assertEqual(t, len(sr.Results), 1)
+		assertEqual(t, sr.Results[0].Snippet, "…"+strings.Repeat("word ", 4)+"<mark>zebrabird</mark> "+strings.Repeat("word ", 10)+"word…")
+	}
+}
+
+func uploadHelper(t *testing.T, token string, data []byte, v interface{}) int {
+	return uploadFileHelper(t, token, "upload", data, v)
+}
+
+func uploadFileHelper(t *testing.T, token string, filename string, data []byte, v interface{}) int {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", baseUrl+"/uploads", &body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	json.NewDecoder(resp.Body).Decode(v)
+	return resp.StatusCode
+}
+
+/*
+Test Scenario:
+1. User1 uploads a png, the type and dimensions are read from the image, other files are rejected
+2. User1 sends an image message from the upload to user2, users can't send uploads of others
+3. The uploader and the recipient can download the upload, other users can't
+4. Once the image message is deleted only the uploader can download the upload
+*/
+func TestUploads(t *testing.T) {
+	createUserHelper("test_upload1", "test_password")
+	user2id, _ := createUserHelper("test_upload2", "test_password")
+	createUserHelper("test_upload3", "test_password")
+	token1, err := loginHelper("test_upload1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_upload2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token3, err := loginHelper("test_upload3", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 3, 2)))
+
+	// Test upload detects type and dimensions
+	var upload controllers.UploadResponse
+	{
+		status := uploadHelper(t, token1, pngData.Bytes(), &upload)
+		assertEqual(t, status, http.StatusOK)
+		assertNotEqual(t, upload.Id, "")
+		assertEqual(t, upload.Url, "/uploads/"+upload.Id)
+		assertEqual(t, upload.MimeType, "image/png")
+		assertEqual(t, upload.Width, 3)
+		assertEqual(t, upload.Height, 2)
+		assertEqual(t, upload.Size, int64(pngData.Len()))
+
+		status = uploadHelper(t, token1, []byte("not an image"), nil)
+		assertEqual(t, status, http.StatusUnsupportedMediaType)
+	}
+
+	// Test sending an image message from the upload
+	var sr controllers.SendMessageResponse
+	{
+		status := doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "image", "upload_id": "%s"}}`, user2id, upload.Id), &sr)
+		assertEqual(t, status, http.StatusOK)
+
+		var gr controllers.GetMessagesResponse
+		getHelper(t, token2, fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=%d", user2id, sr.Id), &gr)
+		assertEqual(t, len(gr.Messages), 1)
+		assertEqual(t, gr.Messages[0].Content.Type, "image")
+		assertEqual(t, imageOf(gr.Messages[0].Content).UploadID, upload.Id)
+		assertEqual(t, imageOf(gr.Messages[0].Content).Url, upload.Url)
+		assertEqual(t, imageOf(gr.Messages[0].Content).Width, 3)
+		assertEqual(t, imageOf(gr.Messages[0].Content).Height, 2)
+
+		status = doHelper(t, "POST", token2, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "image", "upload_id": "%s"}}`, user2id, upload.Id), nil)
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+
+	// Test downloads
+	{
+		for _, token := range []string{token1, token2} {
+			req, _ := http.NewRequest("GET", baseUrl+upload.Url, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			assertEqual(t, resp.StatusCode, http.StatusOK)
+			assertEqual(t, resp.Header.Get("Content-Type"), "image/png")
+			assertEqual(t, bytes.Equal(data, pngData.Bytes()), true)
+		}
+
+		status := getHelper(t, token3, baseUrl+upload.Url, nil)
+		assertEqual(t, status, http.StatusNotFound)
+		status = getHelper(t, token1, baseUrl+"/uploads/0123456789abcdef", nil)
+		assertEqual(t, status, http.StatusNotFound)
+	}
+
+	// Test downloads after the message is deleted
+	{
+		status := doHelper(t, "DELETE", token1, fmt.Sprintf(baseUrl+"/messages/%d", sr.Id), "", nil)
+		assertEqual(t, status, http.StatusNoContent)
+		status = getHelper(t, token2, baseUrl+upload.Url, nil)
+		assertEqual(t, status, http.StatusNotFound)
+		req, _ := http.NewRequest("GET", baseUrl+upload.Url, nil)
+		req.Header.Set("Authorization", "Bearer "+token1)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		assertEqual(t, resp.StatusCode, http.StatusOK)
+	}
+}
+
+/*
+Test Scenario:
+1. User1 sends user2 an image message from a 600x400 upload
+2. A 256px thumbnail is made in the background, no 1024px preview as the image is smaller
+3. The recipient can download the thumbnail, other users can't
+*/
+func TestThumbnails(t *testing.T) {
+	createUserHelper("test_thumb1", "test_password")
+	user2id, _ := createUserHelper("test_thumb2", "test_password")
+	createUserHelper("test_thumb3", "test_password")
+	token1, err := loginHelper("test_thumb1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_thumb2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token3, err := loginHelper("test_thumb3", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 600, 400)))
+	var upload controllers.UploadResponse
+	uploadHelper(t, token1, pngData.Bytes(), &upload)
+	var sr controllers.SendMessageResponse
+	status := doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "image", "upload_id": "%s"}}`, user2id, upload.Id), &sr)
+	assertEqual(t, status, http.StatusOK)
+
+	// Test the thumbnail url is set once the thumbnail is made
+	var msg controllers.Message
+	{
+		messagesUrl := fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=%d", user2id, sr.Id)
+		deadline := time.Now().Add(5 * time.Second)
+		for imageOf(msg.Content).ThumbnailUrl == "" && time.Now().Before(deadline) {
+			var gr controllers.GetMessagesResponse
+			getHelper(t, token2, messagesUrl, &gr)
+			assertEqual(t, len(gr.Messages), 1)
+			msg = gr.Messages[0]
+			time.Sleep(50 * time.Millisecond)
+		}
+		assertEqual(t, imageOf(msg.Content).ThumbnailUrl, fmt.Sprintf("/messages/%d/thumbnails/256", sr.Id))
+		assertEqual(t, imageOf(msg.Content).PreviewUrl, "")
+		assertEqual(t, imageOf(msg.Content).Url, upload.Url)
+	}
+
+	// Test downloading the thumbnail
+	{
+		req, _ := http.NewRequest("GET", baseUrl+imageOf(msg.Content).ThumbnailUrl, nil)
+		req.Header.Set("Authorization", "Bearer "+token2)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		assertEqual(t, resp.StatusCode, http.StatusOK)
+		assertEqual(t, resp.Header.Get("Content-Type"), "image/jpeg")
+		config, format, err := image.DecodeConfig(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, format, "jpeg")
+		assertEqual(t, config.Width, 256)
+		assertEqual(t, config.Height, 171)
+
+		status := getHelper(t, token3, baseUrl+imageOf(msg.Content).ThumbnailUrl, nil)
+		assertEqual(t, status, http.StatusNotFound)
+	}
+}
+
+/*
+Test Scenario:
+1. User1 sends videos with the url forms of youtube and vimeo, they are stored with the canonical url
+2. Fetched videos have the video id and the embed url
+3. Urls of other sites, of the other source or without a video id are rejected
+*/
+func TestVideoMessages(t *testing.T) {
+	createUserHelper("test_video1", "test_password")
+	user2id, _ := createUserHelper("test_video2", "test_password")
+	token1, err := loginHelper("test_video1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	videos := []struct {
+		source    string
+		url       string
+		id        string
+		canonical string
+		embed     string
+	}{
+		{"youtube", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=42s", "dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "https://www.youtube.com/embed/dQw4w9WgXcQ"},
+		{"youtube", "http://m.youtube.com/watch?feature=share&v=dQw4w9WgXcQ", "dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "https://www.youtube.com/embed/dQw4w9WgXcQ"},
+		{"youtube", "https://youtu.be/dQw4w9WgXcQ?si=abc", "dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "https://www.youtube.com/embed/dQw4w9WgXcQ"},
+		{"youtube", "https://www.youtube.com/shorts/a-b_c123456", "a-b_c123456", "https://www.youtube.com/watch?v=a-b_c123456", "https://www.youtube.com/embed/a-b_c123456"},
+		{"youtube", "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ", "dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "https://www.youtube.com/embed/dQw4w9WgXcQ"},
+		{"vimeo", "https://vimeo.com/76979871", "76979871", "https://vimeo.com/76979871", "https://player.vimeo.com/video/76979871"},
+		{"vimeo", "https://vimeo.com/channels/staffpicks/76979871", "76979871", "https://vimeo.com/76979871", "https://player.vimeo.com/video/76979871"},
+		{"vimeo", "https://player.vimeo.com/video/76979871?autoplay=1", "76979871", "https://vimeo.com/76979871", "https://player.vimeo.com/video/76979871"},
+	}
+
+	// Test every url form is stored with the canonical url
+	for _, video := range videos {
+		var sr controllers.SendMessageResponse
+		status := doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "video", "source": "%s", "url": "%s"}}`, user2id, video.source, video.url), &sr)
+		assertEqual(t, status, http.StatusOK)
+
+		var gr controllers.GetMessagesResponse
+		getHelper(t, token1, fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=%d", user2id, sr.Id), &gr)
+		assertEqual(t, len(gr.Messages), 1)
+		assertEqual(t, videoOf(gr.Messages[0].Content).Url, video.canonical)
+		assertEqual(t, videoOf(gr.Messages[0].Content).VideoID, video.id)
+		assertEqual(t, videoOf(gr.Messages[0].Content).EmbedUrl, video.embed)
+	}
+
+	// Test invalid urls are rejected
+	invalid := []struct {
+		source string
+		url    string
+	}{
+		{"youtube", "http://some_video_url"},
+		{"youtube", "https://evil.example.com/watch?v=dQw4w9WgXcQ"},
+		{"youtube", "https://www.youtube.com/watch?v=short"},
+		{"youtube", "https://www.youtube.com/channel/UC38IQsAvIsxxjztdMZQtwHA"},
+		{"youtube", "javascript:alert(1)//youtu.be/dQw4w9WgXcQ"},
+		{"youtube", "https://vimeo.com/76979871"},
+		{"vimeo", "https://youtu.be/dQw4w9WgXcQ"},
+		{"vimeo", "https://vimeo.com/staffpicks"},
+		{"vimeo", "https://vimeo.example.com/76979871"},
+	}
+	for _, video := range invalid {
+		status := doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "video", "source": "%s", "url": "%s"}}`, user2id, video.source, video.url), nil)
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+}
+
+/*
+Test Scenario:
+1. User1 uploads a pdf, the type, size and sha256 checksum are read from the file
+2. Files larger than max_file_size or of types not in file_types are rejected
+3. User1 sends a file message from the upload to user2, it is fetched with the file description
+4. The recipient downloads the file as an attachment, a pdf can't be sent as an image
+*/
+func TestFileMessages(t *testing.T) {
+	createUserHelper("test_file1", "test_password")
+	user2id, _ := createUserHelper("test_file2", "test_password")
+	token1, err := loginHelper("test_file1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_file2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdfData := []byte("%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\ntrailer << /Root 1 0 R >>\n%%EOF\n")
+	sum := sha256.Sum256(pdfData)
+
+	// Test upload of a file
+	var upload controllers.UploadResponse
+	{
+		status := uploadFileHelper(t, token1, "receipt.pdf", pdfData, &upload)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, upload.Filename, "receipt.pdf")
+		assertEqual(t, upload.MimeType, "application/pdf")
+		assertEqual(t, upload.Size, int64(len(pdfData)))
+		assertEqual(t, upload.Sha256, hex.EncodeToString(sum[:]))
+		assertEqual(t, upload.Width, 0)
+
+		large := append([]byte("%PDF-1.4\n"), make([]byte, 70000)...)
+		status = uploadFileHelper(t, token1, "large.pdf", large, nil)
+		assertEqual(t, status, http.StatusRequestEntityTooLarge)
+		status = uploadFileHelper(t, token1, "notes.txt", []byte("plain text is not allowed"), nil)
+		assertEqual(t, status, http.StatusUnsupportedMediaType)
+	}
+
+	// Test sending and fetching a file message
+	{
+		var sr controllers.SendMessageResponse
+		status := doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "file", "upload_id": "%s", "filename": "other.exe", "size": 1}}`, user2id, upload.Id), &sr)
+		assertEqual(t, status, http.StatusOK)
+
+		var gr controllers.GetMessagesResponse
+		getHelper(t, token2, fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=%d", user2id, sr.Id), &gr)
+		assertEqual(t, len(gr.Messages), 1)
+		assertEqual(t, gr.Messages[0].Content.Type, "file")
+		file := fileOf(gr.Messages[0].Content)
+		assertEqual(t, file.UploadID, upload.Id)
+		assertEqual(t, file.Url, upload.Url)
+		assertEqual(t, file.Filename, "receipt.pdf")
+		assertEqual(t, file.MimeType, "application/pdf")
+		assertEqual(t, file.Size, upload.Size)
+		assertEqual(t, file.Sha256, upload.Sha256)
+
+		status = doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "file", "filename": "receipt.pdf"}}`, user2id), nil)
+		assertEqual(t, status, http.StatusBadRequest)
+		status = doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "image", "upload_id": "%s"}}`, user2id, upload.Id), nil)
+		assertEqual(t, status, http.StatusUnsupportedMediaType)
+	}
+
+	// Test the recipient downloads the file as an attachment
+	{
+		req, _ := http.NewRequest("GET", baseUrl+upload.Url, nil)
+		req.Header.Set("Authorization", "Bearer "+token2)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assertEqual(t, resp.StatusCode, http.StatusOK)
+		assertEqual(t, resp.Header.Get("Content-Type"), "application/pdf")
+		assertEqual(t, resp.Header.Get("Content-Disposition"), `attachment; filename=receipt.pdf`)
+		assertEqual(t, bytes.Equal(data, pdfData), true)
+	}
+}
+
+/*
+Test Scenario:
+1. User1 sends user2 a voice note with a waveform and one without
+2. Fetched audio messages have the url, duration, codec and waveform
+3. Audio without url, codec or duration, of other codecs, too long or with invalid waveforms is rejected
+*/
+func TestAudioMessages(t *testing.T) {
+	createUserHelper("test_audio1", "test_password")
+	user2id, _ := createUserHelper("test_audio2", "test_password")
+	token1, err := loginHelper("test_audio1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_audio2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test sending and fetching voice notes
+	{
+		var sr controllers.SendMessageResponse
+		status := doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "audio", "url": "https://cdn.example.com/note.ogg", "duration_ms": 4250, "codec": "Opus", "waveform": [0, 12, 255, 40]}}`, user2id), &sr)
+		assertEqual(t, status, http.StatusOK)
+		status = doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "audio", "url": "https://cdn.example.com/note.m4a", "duration_ms": 900, "codec": "aac"}}`, user2id), nil)
+		assertEqual(t, status, http.StatusOK)
+
+		var gr controllers.GetMessagesResponse
+		getHelper(t, token2, fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=%d", user2id, sr.Id), &gr)
+		assertEqual(t, len(gr.Messages), 2)
+		assertEqual(t, gr.Messages[0].Content.Type, "audio")
+		audio := audioOf(gr.Messages[0].Content)
+		assertEqual(t, audio.Url, "https://cdn.example.com/note.ogg")
+		assertEqual(t, audio.DurationMs, 4250)
+		assertEqual(t, audio.Codec, "opus")
+		assertEqual(t, fmt.Sprint(audio.Waveform), "[0 12 255 40]")
+		assertEqual(t, audioOf(gr.Messages[1].Content).Codec, "aac")
+		assertEqual(t, len(audioOf(gr.Messages[1].Content).Waveform), 0)
+	}
+
+	// Test invalid voice notes are rejected
+	invalid := []string{
+		`{"type": "audio", "duration_ms": 4250, "codec": "opus"}`,
+		`{"type": "audio", "url": "https://cdn.example.com/note.ogg", "duration_ms": 4250}`,
+		`{"type": "audio", "url": "https://cdn.example.com/note.ogg", "codec": "opus"}`,
+		`{"type": "audio", "url": "ftp://cdn.example.com/note.ogg", "duration_ms": 4250, "codec": "opus"}`,
+		`{"type": "audio", "url": "https://cdn.example.com/note.flac", "duration_ms": 4250, "codec": "flac"}`,
+		`{"type": "audio", "url": "https://cdn.example.com/note.ogg", "duration_ms": -1, "codec": "opus"}`,
+		`{"type": "audio", "url": "https://cdn.example.com/note.ogg", "duration_ms": 900001, "codec": "opus"}`,
+		`{"type": "audio", "url": "https://cdn.example.com/note.ogg", "duration_ms": 4250, "codec": "opus", "waveform": [256]}`,
+		`{"type": "audio", "url": "https://cdn.example.com/note.ogg", "duration_ms": 4250, "codec": "opus", "waveform": [-1]}`,
+	}
+	for _, content := range invalid {
+		status := doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":%s}`, user2id, content), nil)
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+}
+
+/*
+Test Scenario:
+1. User1 sends user2 a location at 0,0 with a label, and a live location shared for a minute
+2. Fetched locations have the coordinates, accuracy, label and live_until of live locations
+3. User1 moves the live location by editing it, the label is kept, user2 can't move it
+4. A location that is not live can't be moved, out of range locations are rejected
+*/
+func TestLocationMessages(t *testing.T) {
+	createUserHelper("test_location1", "test_password")
+	user2id, _ := createUserHelper("test_location2", "test_password")
+	token1, err := loginHelper("test_location1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_location2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test sending and fetching locations
+	var staticID, liveID int
+	{
+		var sr controllers.SendMessageResponse
+		status := doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "location", "latitude": 0, "longitude": 0, "label": "Null Island"}}`, user2id), &sr)
+		assertEqual(t, status, http.StatusOK)
+		staticID = sr.Id
+		status = doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "location", "latitude": 40.7484, "longitude": -73.9857, "accuracy": 12.5, "label": "Office", "live_period": 60}}`, user2id), &sr)
+		assertEqual(t, status, http.StatusOK)
+		liveID = sr.Id
+
+		var gr controllers.GetMessagesResponse
+		getHelper(t, token2, fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=%d", user2id, staticID), &gr)
+		assertEqual(t, len(gr.Messages), 2)
+		assertEqual(t, gr.Messages[0].Content.Type, "location")
+		static := locationOf(gr.Messages[0].Content)
+		if static.Latitude == nil || static.Longitude == nil {
+			t.Fatal("missing coordinates")
+		}
+		assertEqual(t, *static.Latitude, 0.0)
+		assertEqual(t, *static.Longitude, 0.0)
+		assertEqual(t, static.Label, "Null Island")
+		assertEqual(t, static.LiveUntil, "")
+
+		live := locationOf(gr.Messages[1].Content)
+		assertEqual(t, *live.Latitude, 40.7484)
+		assertEqual(t, *live.Longitude, -73.9857)
+		assertEqual(t, live.Accuracy, 12.5)
+		liveUntil, err := time.Parse(time.RFC3339, live.LiveUntil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if liveUntil.Before(time.Now().Add(30*time.Second)) || liveUntil.After(time.Now().Add(90*time.Second)) {
+			t.Fatalf("live_until %s is not a minute from now", live.LiveUntil)
+		}
+	}
+
+	// Test moving the live location
+	{
+		liveUrl := fmt.Sprintf(baseUrl+"/messages/%d", liveID)
+		status := doHelper(t, "PATCH", token2, liveUrl, `{"content": {"type": "location", "latitude": 1, "longitude": 1}}`, nil)
+		assertEqual(t, status, http.StatusForbidden)
+
+		var msg controllers.Message
+		status = doHelper(t, "PATCH", token1, liveUrl, `{"content": {"type": "location", "latitude": 40.7411, "longitude": -73.9897, "accuracy": 5}}`, &msg)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, *locationOf(msg.Content).Latitude, 40.7411)
+		assertEqual(t, *locationOf(msg.Content).Longitude, -73.9897)
+		assertEqual(t, locationOf(msg.Content).Accuracy, 5.0)
+		assertEqual(t, locationOf(msg.Content).Label, "Office")
+		assertNotEqual(t, locationOf(msg.Content).LiveUntil, "")
+
+		status = doHelper(t, "PATCH", token1, fmt.Sprintf(baseUrl+"/messages/%d", staticID), `{"content": {"type": "location", "latitude": 1, "longitude": 1}}`, nil)
+		assertEqual(t, status, http.StatusBadRequest)
+		status = doHelper(t, "PATCH", token1, liveUrl, `{"content": {"type": "location", "latitude": 91, "longitude": 1}}`, nil)
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+
+	// Test invalid locations are rejected
+	invalid := []string{
+		`{"type": "location", "latitude": 10}`,
+		`{"type": "location", "longitude": 10}`,
+		`{"type": "location", "latitude": -90.5, "longitude": 10}`,
+		`{"type": "location", "latitude": 10, "longitude": 180.1}`,
+		`{"type": "location", "latitude": 10, "longitude": 10, "accuracy": -1}`,
+		`{"type": "location", "latitude": 10, "longitude": 10, "live_period": 30}`,
+		`{"type": "location", "latitude": 10, "longitude": 10, "live_period": 86401}`,
+		fmt.Sprintf(`{"type": "location", "latitude": 10, "longitude": 10, "label": "%s"}`, strings.Repeat("a", 201)),
+	}
+	for _, content := range invalid {
+		status := doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":%s}`, user2id, content), nil)
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+}
+
+/*
+Test Scenario:
+1. User1 sends user2 a text with a link, its preview is fetched in the background
+2. Fetched messages include the preview, texts with links without preview have none
+3. Editing the link out of the text removes the preview
+4. The http fetcher reads the OpenGraph tags of a page and refuses private addresses
+*/
+func TestLinkPreviews(t *testing.T) {
+	createUserHelper("test_preview1", "test_password")
+	user2id, _ := createUserHelper("test_preview2", "test_password")
+	token1, err := loginHelper("test_preview1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_preview2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeFetcher.Add("https://news.example.com/article?id=1", linkpreview.Preview{
+		Title:       "Article",
+		Description: "An article",
+		ImageUrl:    "https://news.example.com/article.png",
+	})
+
+	// Test the preview is added once fetched
+	var sr controllers.SendMessageResponse
+	{
+		status := doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "text", "text": "Read this (https://news.example.com/article?id=1)."}}`, user2id), &sr)
+		assertEqual(t, status, http.StatusOK)
+		status = doHelper(t, "POST", token1, baseUrl+"/messages", fmt.Sprintf(`{"recipient": %d, "content":{"type": "text", "text": "No preview for https://unknown.example.com"}}`, user2id), nil)
+		assertEqual(t, status, http.StatusOK)
+
+		messagesUrl := fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=%d", user2id, sr.Id)
+		var gr controllers.GetMessagesResponse
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			getHelper(t, token2, messagesUrl, &gr)
+			assertEqual(t, len(gr.Messages), 2)
+			if textOf(gr.Messages[0].Content).LinkPreview != nil {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		preview := textOf(gr.Messages[0].Content).LinkPreview
+		if preview == nil {
+			t.Fatal("link preview not added")
+		}
+		assertEqual(t, preview.Url, "https://news.example.com/article?id=1")
+		assertEqual(t, preview.Title, "Article")
+		assertEqual(t, preview.Description, "An article")
+		assertEqual(t, preview.ImageUrl, "https://news.example.com/article.png")
+		if textOf(gr.Messages[1].Content).LinkPreview != nil {
+			t.Fatal("link without preview has a preview")
+		}
+	}
+
+	// Test editing the link out removes the preview
+	{
+		var msg controllers.Message
+		status := doHelper(t, "PATCH", token1, fmt.Sprintf(baseUrl+"/messages/%d", sr.Id), `{"content": {"type": "text", "text": "Never mind"}}`, &msg)
+		assertEqual(t, status, http.StatusOK)
+		var gr controllers.GetMessagesResponse
+		getHelper(t, token2, fmt.Sprintf(baseUrl+"/messages?recipient=%d&start=%d", user2id, sr.Id), &gr)
+		if textOf(gr.Messages[0].Content).LinkPreview != nil {
+			t.Fatal("preview of an edited out link is kept")
+		}
+	}
+
+	// Test the http fetcher
+	{
+		page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, `<html><head><title>Fallback</title>
+				<meta property="og:title" content="Page &amp; Title">
+				<meta name="description" content="  A   page ">
+				<meta property="og:image" content="/images/page.png">
+				</head><body><meta property="og:title" content="Body"></body></html>`)
+		}))
+		defer page.Close()
+
+		// the server of the test is on a loopback address
+		_, err := linkpreview.NewHTTPFetcher().Fetch(page.URL)
+		assertNotEqual(t, err, nil)
+
+		fetcher := &linkpreview.HTTPFetcher{Client: http.DefaultClient}
+		preview, err := fetcher.Fetch(page.URL + "/page")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, preview.Url, page.URL+"/page")
+		assertEqual(t, preview.Title, "Page & Title")
+		assertEqual(t, preview.Description, "A page")
+		assertEqual(t, preview.ImageUrl, page.URL+"/images/page.png")
+	}
+}
+
+/*
+Test Scenario:
+1. User1 sends five messages to user2
+2. Without start user2 gets the newest page, prev_cursor pages back to the oldest message
+3. next_cursor of a page gets newer messages, at the newest it can be polled for new ones
+4. start and direction page from a message, invalid cursors and directions are rejected, as
+are cursors of another recipient
+5. limit is capped at max_page_size, for conversation messages too
+*/
+func TestMessageCursors(t *testing.T) {
+	user1id, _ := createUserHelper("test_cursor1", "test_password")
+	user2id, _ := createUserHelper("test_cursor2", "test_password")
+	token1, err := loginHelper("test_cursor1", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := loginHelper("test_cursor2", "test_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, text := range []string{"one", "two", "three", "four", "five"} {
+		ids = append(ids, sendTextHelper(t, token1, user2id, text))
+	}
+	messagesUrl := fmt.Sprintf(baseUrl+"/messages?recipient=%d", user2id)
+	assertIDs := func(gr controllers.GetMessagesResponse, expected ...int) {
+		assertEqual(t, len(gr.Messages), len(expected))
+		for i, id := range expected {
+			assertEqual(t, gr.Messages[i].MsgID, id)
+		}
+	}
+
+	// Test newest page first, then older pages up to the oldest message
+	var newest controllers.GetMessagesResponse
+	{
+		status := getHelper(t, token2, messagesUrl+"&limit=2", &newest)
+		assertEqual(t, status, http.StatusOK)
+		assertIDs(newest, ids[3], ids[4])
+		assertNotEqual(t, newest.NextCursor, "")
+		assertNotEqual(t, newest.PrevCursor, "")
+
+		var older controllers.GetMessagesResponse
+		getHelper(t, token2, messagesUrl+"&limit=2&cursor="+newest.PrevCursor, &older)
+		assertIDs(older, ids[1], ids[2])
+		assertNotEqual(t, older.PrevCursor, "")
+
+		var oldest controllers.GetMessagesResponse
+		getHelper(t, token2, messagesUrl+"&limit=2&cursor="+older.PrevCursor, &oldest)
+		assertIDs(oldest, ids[0])
+		assertEqual(t, oldest.PrevCursor, "")
+
+		var newer controllers.GetMessagesResponse
+		getHelper(t, token2, messagesUrl+"&limit=2&cursor="+oldest.NextCursor, &newer)
+		assertIDs(newer, ids[1], ids[2])
+	}
+
+	// Test polling past the newest message
+	{
+		var gr controllers.GetMessagesResponse
+		getHelper(t, token2, messagesUrl+"&cursor="+newest.NextCursor, &gr)
+		assertIDs(gr)
+		assertNotEqual(t, gr.NextCursor, "")
+
+		sixth := sendTextHelper(t, token1, user2id, "six")
+		var polled controllers.GetMessagesResponse
+		getHelper(t, token2, messagesUrl+"&cursor="+gr.NextCursor, &polled)
+		assertIDs(polled, sixth)
+	}
+
+	// Test start with both directions
+	{
+		var gr controllers.GetMessagesResponse
+		getHelper(t, token2, fmt.Sprintf(messagesUrl+"&start=%d&limit=2", ids[1]), &gr)
+		assertIDs(gr, ids[1], ids[2])
+		assertNotEqual(t, gr.PrevCursor, "")
+
+		getHelper(t, token2, fmt.Sprintf(messagesUrl+"&start=%d&direction=older&limit=2", ids[2]), &gr)
+		assertIDs(gr, ids[1], ids[2])
+
+		getHelper(t, token2, messagesUrl+"&direction=newer&limit=1", &gr)
+		assertIDs(gr, ids[0])
+	}
+
+	// Test invalid cursor and direction
+	{
+		var gr controllers.GetMessagesResponse
+		status := getHelper(t, token2, messagesUrl+"&cursor=notacursor", &gr)
+		assertEqual(t, status, http.StatusBadRequest)
+		status = getHelper(t, token2, fmt.Sprintf(messagesUrl+"&cursor=%d", ids[0]), &gr)
+		assertEqual(t, status, http.StatusBadRequest)
+		status = getHelper(t, token2, messagesUrl+"&direction=sideways", &gr)
+		assertEqual(t, status, http.StatusBadRequest)
+		// a cursor pages only the recipient it was returned for
+		status = getHelper(t, token2, fmt.Sprintf(baseUrl+"/messages?recipient=%d&cursor=%s", user1id, newest.PrevCursor), &gr)
+		assertEqual(t, status, http.StatusBadRequest)
+	}
+
+	// Test limit capped at max_page_size of the test config, 50
+	{
+		for i := 0; i < 50; i++ {
+			sendTextHelper(t, token1, user2id, fmt.Sprint("message ", i))
+		}
+		var gr controllers.GetMessagesResponse
+		status := getHelper(t, token2, messagesUrl+"&limit=1000", &gr)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, len(gr.Messages), 50)
+
+		var cr controllers.GetConversationMessagesResponse
+		status = getHelper(t, token2, fmt.Sprintf(baseUrl+"/conversations/%d/messages?limit=1000", user1id), &cr)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, len(cr.Messages), 50)
+	}
 }
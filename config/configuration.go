@@ -5,12 +5,27 @@ import (
 	"os"
 )
 
+// JWTKey is one entry of the jwt key ring, identified by the kid token header
+type JWTKey struct {
+	Kid     string `json:"kid"`
+	Secret  string `json:"secret"`
+	Retired bool   `json:"retired"`
+}
+
 type Configuration struct {
-	Host      string `json:"host"`
-	Port      string `json:"port"`
-	DBDriver  string `json:"db_driver"`
-	DBName    string `json:"db_name"`
-	JWTSecret string `json:"jwt_secret"`
+	Host         string   `json:"host"`
+	Port         string   `json:"port"`
+	DBDriver     string   `json:"db_driver"`
+	DBName       string   `json:"db_name"`
+	JWTSecret    string   `json:"jwt_secret"`
+	JWTKeys      []JWTKey `json:"jwt_keys"`
+	JWTActiveKid string   `json:"jwt_active_kid"`
+	UploadDir    string   `json:"upload_dir"`
+	// largest file of a file message in bytes and the mime types it can have
+	MaxFileSize int64    `json:"max_file_size"`
+	FileTypes   []string `json:"file_types"`
+	// most messages returned by a page of GET /messages
+	MaxPageSize int `json:"max_page_size"`
 }
 
 const configFilePath = "config/"
@@ -0,0 +1,139 @@
+package content
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+)
+
+const (
+	ErrorInvalidAudioUrl   = "error audio url must be an http or https url"
+	ErrorCodecNotSupported = "error audio codec not supported"
+	ErrorInvalidDuration   = "error audio duration must be 1ms to 15 minutes"
+	ErrorInvalidWaveform   = "error waveform must be at most 256 samples of 0 to 255"
+)
+
+var errorInvalidAudioUrl = errors.New(ErrorInvalidAudioUrl)
+var errorCodecNotSupported = errors.New(ErrorCodecNotSupported)
+var errorInvalidDuration = errors.New(ErrorInvalidDuration)
+var errorInvalidWaveform = errors.New(ErrorInvalidWaveform)
+
+const (
+	// longest voice note, in milliseconds
+	maxAudioDuration   = 15 * 60 * 1000
+	maxWaveformSamples = 256
+)
+
+// codecs the apps can play
+var audioCodecs = map[string]bool{
+	"opus":   true,
+	"aac":    true,
+	"mp3":    true,
+	"vorbis": true,
+	"amr":    true,
+}
+
+// Audio is the payload of audio messages, voice notes recorded in the apps, stored in audios.
+// The waveform has one sample of 0 to 255 per slice of the duration, stored one byte per sample
+type Audio struct {
+	Url        string `json:"url"`
+	DurationMs int    `json:"duration_ms"`
+	Codec      string `json:"codec"`
+	Waveform   []int  `json:"waveform,omitempty"`
+}
+
+type audio struct{}
+
+func init() {
+	Register(audio{})
+}
+
+func (audio) Name() string {
+	return "audio"
+}
+
+func (audio) Decode(data json.RawMessage) (Payload, error) {
+	var a Audio
+	err := json.Unmarshal(data, &a)
+	return a, err
+}
+
+func (audio) Validate(p Payload) (Payload, error) {
+	a, ok := p.(Audio)
+	if !ok || a.Url == "" || a.Codec == "" || a.DurationMs == 0 {
+		log.Println(errorMissingArgument)
+		return p, errorMissingArgument
+	}
+	u, err := url.Parse(strings.TrimSpace(a.Url))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Println(errorInvalidAudioUrl)
+		return p, errorInvalidAudioUrl
+	}
+	codec := strings.ToLower(a.Codec)
+	if !audioCodecs[codec] {
+		log.Println(errorCodecNotSupported)
+		return p, errorCodecNotSupported
+	}
+	if a.DurationMs < 0 || a.DurationMs > maxAudioDuration {
+		log.Println(errorInvalidDuration)
+		return p, errorInvalidDuration
+	}
+	if len(a.Waveform) > maxWaveformSamples {
+		log.Println(errorInvalidWaveform)
+		return p, errorInvalidWaveform
+	}
+	for _, sample := range a.Waveform {
+		if sample < 0 || sample > 255 {
+			log.Println(errorInvalidWaveform)
+			return p, errorInvalidWaveform
+		}
+	}
+	return Audio{Url: u.String(), DurationMs: a.DurationMs, Codec: codec, Waveform: a.Waveform}, nil
+}
+
+func (audio) Insert(tx Execer, msgID int, p Payload) error {
+	a := p.(Audio)
+	var waveform []byte
+	for _, sample := range a.Waveform {
+		waveform = append(waveform, byte(sample))
+	}
+	_, err := tx.Exec("INSERT INTO audios (msg_id, a_url, duration_ms, codec, waveform) VALUES (?, ?, ?, ?, ?)", msgID, a.Url, a.DurationMs, a.Codec, waveform)
+	if err != nil {
+		log.Println("error inserting audio into audios table", err.Error())
+	}
+	return err
+}
+
+func (audio) Load(q Querier, msgIDs []int) (map[int]Payload, error) {
+	list, args := inList(msgIDs)
+	res, err := q.Query("SELECT msg_id, a_url, duration_ms, codec, waveform FROM audios WHERE msg_id IN "+list, args...)
+	if err != nil {
+		log.Println("error retrieving audios", err.Error())
+		return nil, err
+	}
+	defer res.Close()
+
+	payloads := make(map[int]Payload)
+	for res.Next() {
+		var msgID int
+		var waveform []byte
+		var a Audio
+		err := res.Scan(&msgID, &a.Url, &a.DurationMs, &a.Codec, &waveform)
+		if err != nil {
+			log.Println("error scanning audios", err.Error())
+			return nil, err
+		}
+		for _, sample := range waveform {
+			a.Waveform = append(a.Waveform, int(sample))
+		}
+		payloads[msgID] = a
+	}
+	return payloads, res.Err()
+}
+
+func (audio) Delete(tx Execer, msgID int) error {
+	_, err := tx.Exec("DELETE FROM audios WHERE msg_id = ?", msgID)
+	return err
+}
@@ -0,0 +1,160 @@
+// Package content holds the registry of message content types. Each type validates,
+// stores and loads its own content, adding a type is adding a file registering it and
+// a migration creating its table
+package content
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+)
+
+const (
+	ErrorMissingArgument  = "error missing argument"
+	ErrorTypeNotSupported = "error type of message not supported"
+	ErrorInvalidPayload   = "error content payload must encode to a JSON object"
+)
+
+var errorMissingArgument = errors.New(ErrorMissingArgument)
+var errorTypeNotSupported = errors.New(ErrorTypeNotSupported)
+var errorInvalidPayload = errors.New(ErrorInvalidPayload)
+
+// Content is the content of a message, a Payload of its Type. In JSON the fields of the
+// payload are sent next to the type
+type Content struct {
+	Type    string
+	Payload Payload
+}
+
+// Payload is the content of a message of one type, the struct defined in the file of the type
+type Payload interface{}
+
+func (c Content) MarshalJSON() ([]byte, error) {
+	typeField, err := json.Marshal(c.Type)
+	if err != nil {
+		return nil, err
+	}
+	fields := []byte("{}")
+	if c.Payload != nil {
+		fields, err = json.Marshal(c.Payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(fields) < 2 || fields[0] != '{' {
+		return nil, errorInvalidPayload
+	}
+	if string(fields) == "{}" {
+		return []byte(`{"type":` + string(typeField) + "}"), nil
+	}
+	return append([]byte(`{"type":`+string(typeField)+","), fields[1:]...), nil
+}
+
+// the payload is decoded by the type, contents of types not registered have no payload
+// and are rejected by Validate
+func (c *Content) UnmarshalJSON(data []byte) error {
+	var head struct {
+		Type string `json:"type"`
+	}
+	err := json.Unmarshal(data, &head)
+	if err != nil {
+		return err
+	}
+	c.Type, c.Payload = head.Type, nil
+	if t, found := Lookup(head.Type); found {
+		c.Payload, err = t.Decode(data)
+	}
+	return err
+}
+
+// Execer runs statements, *sql.DB and *sql.Tx are both Execers
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Querier runs queries, *sql.DB and *sql.Tx are both Queriers
+type Querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Type is a kind of message content
+type Type interface {
+	// name of the type, the type of the messages and of their content
+	Name() string
+	// decode the payload of the type from the JSON of a content
+	Decode(data json.RawMessage) (Payload, error)
+	// check the payload sent in a message and return it in the form it is stored
+	Validate(p Payload) (Payload, error)
+	// store the payload of message msgID
+	Insert(tx Execer, msgID int, p Payload) error
+	// load the payloads of the messages msgIDs by msg_id, there are at most 500 ids
+	Load(q Querier, msgIDs []int) (map[int]Payload, error)
+	// remove the content of message msgID, the message is kept as a tombstone
+	Delete(tx Execer, msgID int) error
+}
+
+// Updater is a Type whose content can be changed after it is sent
+type Updater interface {
+	Type
+	// replace the stored payload of message msgID, p is validated
+	Update(tx Execer, msgID int, p Payload) error
+	// the payload stored after Update, for stores that keep payloads as they are
+	Merge(stored Payload, p Payload) (Payload, error)
+}
+
+var types = make(map[string]Type)
+
+// Register makes a type available, it is called from the init of the file defining the type
+func Register(t Type) {
+	if _, found := types[t.Name()]; found {
+		panic("content type registered twice: " + t.Name())
+	}
+	types[t.Name()] = t
+}
+
+// Lookup finds a registered type by name
+func Lookup(name string) (Type, bool) {
+	t, found := types[name]
+	return t, found
+}
+
+// Names of the registered types, sorted
+func Names() []string {
+	var names []string
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks c with its type and returns it in the form it is stored
+func Validate(c Content) (Content, error) {
+	if c.Type == "" {
+		log.Println(errorMissingArgument)
+		return c, errorMissingArgument
+	}
+	t, found := Lookup(c.Type)
+	if !found {
+		log.Println(errorTypeNotSupported)
+		return c, errorTypeNotSupported
+	}
+	p, err := t.Validate(c.Payload)
+	if err != nil {
+		return c, err
+	}
+	return Content{c.Type, p}, nil
+}
+
+// placeholders and arguments of an IN (...) list of ids, SQLite allows at most 999
+// variables so lists are kept to 500 ids by the caller
+func inList(ids []int) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return "(?" + strings.Repeat(", ?", len(ids)-1) + ")", args
+}
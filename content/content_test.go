@@ -0,0 +1,117 @@
+package content
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// a type used only by the tests, registered and removed by them
+type fake struct{}
+
+func (fake) Name() string                                 { return "fake" }
+func (fake) Decode(data json.RawMessage) (Payload, error) { return nil, nil }
+func (fake) Validate(p Payload) (Payload, error)          { return p, nil }
+func (fake) Insert(tx Execer, msgID int, p Payload) error { return nil }
+func (fake) Load(q Querier, msgIDs []int) (map[int]Payload, error) {
+	return nil, nil
+}
+func (fake) Delete(tx Execer, msgID int) error { return nil }
+
+func TestRegisterTwice(t *testing.T) {
+	Register(fake{})
+	defer delete(types, "fake")
+	if _, found := Lookup("fake"); !found {
+		t.Fatal("registered type not found")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a type twice did not panic")
+		}
+	}()
+	Register(fake{})
+}
+
+func TestLookup(t *testing.T) {
+	text, found := Lookup("text")
+	if !found || text.Name() != "text" {
+		t.Fatalf("text not found, got %v", text)
+	}
+	if _, found := Lookup("hologram"); found {
+		t.Fatal("unknown type found")
+	}
+	if _, err := Validate(Content{Type: "hologram"}); err != errorTypeNotSupported {
+		t.Fatalf("expected %v, got %v", errorTypeNotSupported, err)
+	}
+}
+
+func TestNames(t *testing.T) {
+	names := Names()
+	expected := []string{"audio", "file", "image", "location", "text", "video"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	for _, name := range names {
+		if _, found := Lookup(name); !found {
+			t.Fatalf("%s is named but not found", name)
+		}
+	}
+}
+
+func TestContentJSON(t *testing.T) {
+	var c Content
+	err := json.Unmarshal([]byte(`{"type": "image", "width": 2, "height": 1, "url": "https://example.com/a.png"}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Content{Type: "image", Payload: Image{Width: 2, Height: 1, Url: "https://example.com/a.png"}}
+	if !reflect.DeepEqual(c, expected) {
+		t.Fatalf("expected %v, got %v", expected, c)
+	}
+
+	data, err := json.Marshal(Content{Type: "text", Payload: Text{Text: "hi"}})
+	if err != nil || string(data) != `{"type":"text","text":"hi"}` {
+		t.Fatalf("unexpected encoding %s %v", data, err)
+	}
+	data, err = json.Marshal(Content{Type: "deleted"})
+	if err != nil || string(data) != `{"type":"deleted"}` {
+		t.Fatalf("unexpected encoding %s %v", data, err)
+	}
+
+	// types not registered have no payload and are rejected
+	err = json.Unmarshal([]byte(`{"type": "hologram", "text": "hi"}`), &c)
+	if err != nil || c.Type != "hologram" || c.Payload != nil {
+		t.Fatalf("unexpected decoding %v %v", c, err)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	est := time.FixedZone("EST", -5*60*60)
+	scans := []struct {
+		src      interface{}
+		expected sql.NullString
+	}{
+		{nil, sql.NullString{}},
+		{time.Date(2018, 8, 4, 0, 6, 22, 500, est), sql.NullString{String: "2018-08-04T05:06:22Z", Valid: true}},
+		{"2018-08-04 05:06:22", sql.NullString{String: "2018-08-04T05:06:22Z", Valid: true}},
+		{[]byte("2018-08-04T00:06:22-05:00"), sql.NullString{String: "2018-08-04T05:06:22Z", Valid: true}},
+	}
+	for _, scan := range scans {
+		var ts sql.NullString
+		err := Timestamp(&ts).Scan(scan.src)
+		if err != nil || ts != scan.expected {
+			t.Fatalf("%v: expected %v, got %v %v", scan.src, scan.expected, ts, err)
+		}
+	}
+
+	var required string
+	if err := RequiredTimestamp(&required).Scan(nil); err == nil {
+		t.Fatal("NULL scanned into a required timestamp")
+	}
+	if err := Timestamp(&sql.NullString{}).Scan("yesterday"); err == nil {
+		t.Fatal("invalid timestamp scanned")
+	}
+}
@@ -0,0 +1,95 @@
+package content
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+)
+
+const ErrorInvalidFile = "error file must have a filename, mime type, size and sha256 checksum"
+
+var errorInvalidFile = errors.New(ErrorInvalidFile)
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// longest filename of a file message, in bytes
+const maxFilenameSize = 255
+
+// File is the payload of file messages, stored in files. The file is an upload, described by
+// the upload it was sent from
+type File struct {
+	Url      string `json:"url"`
+	UploadID string `json:"upload_id"`
+	Filename string `json:"filename"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	Sha256   string `json:"sha256"`
+}
+
+type file struct{}
+
+func init() {
+	Register(file{})
+}
+
+func (file) Name() string {
+	return "file"
+}
+
+func (file) Decode(data json.RawMessage) (Payload, error) {
+	var f File
+	err := json.Unmarshal(data, &f)
+	return f, err
+}
+
+func (file) Validate(p Payload) (Payload, error) {
+	f, ok := p.(File)
+	if !ok || f.UploadID == "" || f.Url == "" {
+		log.Println(errorMissingArgument)
+		return p, errorMissingArgument
+	}
+	if f.Filename == "" || len(f.Filename) > maxFilenameSize || f.MimeType == "" || f.Size <= 0 || !sha256Hex.MatchString(f.Sha256) {
+		log.Println(errorInvalidFile)
+		return p, errorInvalidFile
+	}
+	return f, nil
+}
+
+func (file) Insert(tx Execer, msgID int, p Payload) error {
+	f := p.(File)
+	query := "INSERT INTO files (msg_id, upload_id, f_url, filename, mime_type, size, sha256) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	_, err := tx.Exec(query, msgID, f.UploadID, f.Url, f.Filename, f.MimeType, f.Size, f.Sha256)
+	if err != nil {
+		log.Println("error inserting file into files table", err.Error())
+	}
+	return err
+}
+
+func (file) Load(q Querier, msgIDs []int) (map[int]Payload, error) {
+	list, args := inList(msgIDs)
+	res, err := q.Query("SELECT msg_id, upload_id, f_url, filename, mime_type, size, sha256 FROM files WHERE msg_id IN "+list, args...)
+	if err != nil {
+		log.Println("error retrieving files", err.Error())
+		return nil, err
+	}
+	defer res.Close()
+
+	payloads := make(map[int]Payload)
+	for res.Next() {
+		var msgID int
+		var f File
+		err := res.Scan(&msgID, &f.UploadID, &f.Url, &f.Filename, &f.MimeType, &f.Size, &f.Sha256)
+		if err != nil {
+			log.Println("error scanning files", err.Error())
+			return nil, err
+		}
+		payloads[msgID] = f
+	}
+	return payloads, res.Err()
+}
+
+func (file) Delete(tx Execer, msgID int) error {
+	_, err := tx.Exec("DELETE FROM files WHERE msg_id = ?", msgID)
+	return err
+}
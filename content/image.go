@@ -0,0 +1,85 @@
+package content
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+)
+
+// Image is the payload of image messages, stored in images. The url is either a link or the
+// url of an upload
+type Image struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Url    string `json:"url"`
+	// image sent from POST /uploads, the url and dimensions are those of the upload
+	UploadID string `json:"upload_id,omitempty"`
+	// downscaled copies of the image, set once made and only when smaller than the image
+	ThumbnailUrl string `json:"thumbnail_url,omitempty"`
+	PreviewUrl   string `json:"preview_url,omitempty"`
+}
+
+type image struct{}
+
+func init() {
+	Register(image{})
+}
+
+func (image) Name() string {
+	return "image"
+}
+
+func (image) Decode(data json.RawMessage) (Payload, error) {
+	var i Image
+	err := json.Unmarshal(data, &i)
+	return i, err
+}
+
+func (image) Validate(p Payload) (Payload, error) {
+	i, ok := p.(Image)
+	if !ok || i.Width <= 0 || i.Height <= 0 || i.Url == "" {
+		log.Println(errorMissingArgument)
+		return p, errorMissingArgument
+	}
+	return Image{Width: i.Width, Height: i.Height, Url: i.Url, UploadID: i.UploadID}, nil
+}
+
+func (image) Insert(tx Execer, msgID int, p Payload) error {
+	i := p.(Image)
+	uploadID := sql.NullString{String: i.UploadID, Valid: i.UploadID != ""}
+	_, err := tx.Exec("INSERT INTO images (msg_id, width, height, i_url, upload_id) VALUES (?, ?, ?, ?, ?)", msgID, i.Width, i.Height, i.Url, uploadID)
+	if err != nil {
+		log.Println("error inserting image into images table", err.Error())
+	}
+	return err
+}
+
+func (image) Load(q Querier, msgIDs []int) (map[int]Payload, error) {
+	list, args := inList(msgIDs)
+	res, err := q.Query("SELECT msg_id, width, height, i_url, upload_id FROM images WHERE msg_id IN "+list, args...)
+	if err != nil {
+		log.Println("error retrieving images", err.Error())
+		return nil, err
+	}
+	defer res.Close()
+
+	payloads := make(map[int]Payload)
+	for res.Next() {
+		var msgID int
+		var uploadID sql.NullString
+		var i Image
+		err := res.Scan(&msgID, &i.Width, &i.Height, &i.Url, &uploadID)
+		if err != nil {
+			log.Println("error scanning images", err.Error())
+			return nil, err
+		}
+		i.UploadID = uploadID.String
+		payloads[msgID] = i
+	}
+	return payloads, res.Err()
+}
+
+func (image) Delete(tx Execer, msgID int) error {
+	_, err := tx.Exec("DELETE FROM images WHERE msg_id = ?", msgID)
+	return err
+}
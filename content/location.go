@@ -0,0 +1,167 @@
+package content
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	ErrorInvalidCoordinates = "error latitude must be -90 to 90 and longitude -180 to 180"
+	ErrorInvalidAccuracy    = "error accuracy must be 0 to 100000 meters"
+	ErrorLabelSize          = "error location label must be at most 200 characters"
+	ErrorInvalidLivePeriod  = "error live_period must be 60 to 86400 seconds"
+	ErrorLocationNotLive    = "error location is not live or its sharing has ended"
+)
+
+var errorInvalidCoordinates = errors.New(ErrorInvalidCoordinates)
+var errorInvalidAccuracy = errors.New(ErrorInvalidAccuracy)
+var errorLabelSize = errors.New(ErrorLabelSize)
+var errorInvalidLivePeriod = errors.New(ErrorInvalidLivePeriod)
+var errorLocationNotLive = errors.New(ErrorLocationNotLive)
+
+const (
+	// in meters
+	maxAccuracy   = 100000
+	maxLabelSize  = 200
+	minLivePeriod = 60
+	maxLivePeriod = 24 * 60 * 60
+)
+
+// Location is the payload of location messages, stored in locations. The coordinates are in
+// degrees, pointers as 0 is a valid coordinate, and the accuracy is in meters. A live location
+// is sent with a live_period in seconds and its coordinates can be updated by editing the
+// message until live_until
+type Location struct {
+	Latitude   *float64 `json:"latitude"`
+	Longitude  *float64 `json:"longitude"`
+	Accuracy   float64  `json:"accuracy,omitempty"`
+	Label      string   `json:"label,omitempty"`
+	LivePeriod int      `json:"live_period,omitempty"`
+	LiveUntil  string   `json:"live_until,omitempty"`
+}
+
+type location struct{}
+
+func init() {
+	Register(location{})
+}
+
+func (location) Name() string {
+	return "location"
+}
+
+func (location) Decode(data json.RawMessage) (Payload, error) {
+	var l Location
+	err := json.Unmarshal(data, &l)
+	return l, err
+}
+
+func (location) Validate(p Payload) (Payload, error) {
+	l, ok := p.(Location)
+	if !ok || l.Latitude == nil || l.Longitude == nil {
+		log.Println(errorMissingArgument)
+		return p, errorMissingArgument
+	}
+	lat, lon := *l.Latitude, *l.Longitude
+	// comparisons are false for NaN, so NaN is rejected too
+	if !(lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180) {
+		log.Println(errorInvalidCoordinates)
+		return p, errorInvalidCoordinates
+	}
+	if !(l.Accuracy >= 0 && l.Accuracy <= maxAccuracy) {
+		log.Println(errorInvalidAccuracy)
+		return p, errorInvalidAccuracy
+	}
+	if utf8.RuneCountInString(l.Label) > maxLabelSize {
+		log.Println(errorLabelSize)
+		return p, errorLabelSize
+	}
+	loc := Location{Latitude: &lat, Longitude: &lon, Accuracy: l.Accuracy, Label: l.Label}
+	if l.LivePeriod != 0 {
+		if l.LivePeriod < minLivePeriod || l.LivePeriod > maxLivePeriod {
+			log.Println(errorInvalidLivePeriod)
+			return p, errorInvalidLivePeriod
+		}
+		loc.LiveUntil = time.Now().UTC().Add(time.Duration(l.LivePeriod) * time.Second).Format(TimestampFormat)
+	}
+	return loc, nil
+}
+
+func (location) Insert(tx Execer, msgID int, p Payload) error {
+	l := p.(Location)
+	liveUntil := sql.NullString{String: l.LiveUntil, Valid: l.LiveUntil != ""}
+	query := "INSERT INTO locations (msg_id, latitude, longitude, accuracy, label, live_until) VALUES (?, ?, ?, ?, ?, ?)"
+	_, err := tx.Exec(query, msgID, *l.Latitude, *l.Longitude, l.Accuracy, l.Label, liveUntil)
+	if err != nil {
+		log.Println("error inserting location into locations table", err.Error())
+	}
+	return err
+}
+
+// move a live location, the label and live_until are kept
+func (location) Update(tx Execer, msgID int, p Payload) error {
+	l := p.(Location)
+	now := time.Now().UTC().Format(TimestampFormat)
+	query := "UPDATE locations SET latitude = ?, longitude = ?, accuracy = ? WHERE msg_id = ? AND live_until > ?"
+	res, err := tx.Exec(query, *l.Latitude, *l.Longitude, l.Accuracy, msgID, now)
+	if err != nil {
+		log.Println("error updating location", err.Error())
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		log.Println(errorLocationNotLive)
+		return errorLocationNotLive
+	}
+	return nil
+}
+
+func (location) Merge(storedPayload Payload, p Payload) (Payload, error) {
+	stored, l := storedPayload.(Location), p.(Location)
+	if stored.LiveUntil <= time.Now().UTC().Format(TimestampFormat) {
+		log.Println(errorLocationNotLive)
+		return storedPayload, errorLocationNotLive
+	}
+	lat, lon := *l.Latitude, *l.Longitude
+	stored.Latitude, stored.Longitude, stored.Accuracy = &lat, &lon, l.Accuracy
+	return stored, nil
+}
+
+func (location) Load(q Querier, msgIDs []int) (map[int]Payload, error) {
+	list, args := inList(msgIDs)
+	res, err := q.Query("SELECT msg_id, latitude, longitude, accuracy, label, live_until FROM locations WHERE msg_id IN "+list, args...)
+	if err != nil {
+		log.Println("error retrieving locations", err.Error())
+		return nil, err
+	}
+	defer res.Close()
+
+	payloads := make(map[int]Payload)
+	for res.Next() {
+		var msgID int
+		var lat, lon float64
+		var liveUntil sql.NullString
+		var l Location
+		err := res.Scan(&msgID, &lat, &lon, &l.Accuracy, &l.Label, Timestamp(&liveUntil))
+		if err != nil {
+			log.Println("error scanning locations", err.Error())
+			return nil, err
+		}
+		l.Latitude, l.Longitude = &lat, &lon
+		l.LiveUntil = liveUntil.String
+		payloads[msgID] = l
+	}
+	return payloads, res.Err()
+}
+
+func (location) Delete(tx Execer, msgID int) error {
+	_, err := tx.Exec("DELETE FROM locations WHERE msg_id = ?", msgID)
+	return err
+}
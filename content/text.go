@@ -0,0 +1,94 @@
+package content
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Text is the payload of text messages, stored in texts
+type Text struct {
+	Text string `json:"text"`
+	// preview of the first link of the text, set once fetched
+	LinkPreview *LinkPreview `json:"link_preview,omitempty"`
+}
+
+// LinkPreview is the OpenGraph title, description and image of a link
+type LinkPreview struct {
+	Url         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	ImageUrl    string `json:"image_url,omitempty"`
+}
+
+type text struct{}
+
+func init() {
+	Register(text{})
+}
+
+func (text) Name() string {
+	return "text"
+}
+
+func (text) Decode(data json.RawMessage) (Payload, error) {
+	var t Text
+	err := json.Unmarshal(data, &t)
+	return t, err
+}
+
+func (text) Validate(p Payload) (Payload, error) {
+	t, ok := p.(Text)
+	if !ok || t.Text == "" {
+		log.Println(errorMissingArgument)
+		return p, errorMissingArgument
+	}
+	return Text{Text: t.Text}, nil
+}
+
+func (text) Insert(tx Execer, msgID int, p Payload) error {
+	_, err := tx.Exec("INSERT INTO texts (msg_id, msg) VALUES (?, ?)", msgID, p.(Text).Text)
+	if err != nil {
+		log.Println("error inserting message into texts table", err.Error())
+	}
+	return err
+}
+
+func (text) Update(tx Execer, msgID int, p Payload) error {
+	_, err := tx.Exec("UPDATE texts SET msg = ? WHERE msg_id = ?", p.(Text).Text, msgID)
+	if err != nil {
+		log.Println("error updating text", err.Error())
+	}
+	return err
+}
+
+func (text) Merge(stored Payload, p Payload) (Payload, error) {
+	return Text{Text: p.(Text).Text}, nil
+}
+
+func (text) Load(q Querier, msgIDs []int) (map[int]Payload, error) {
+	list, args := inList(msgIDs)
+	res, err := q.Query("SELECT msg_id, msg FROM texts WHERE msg_id IN "+list, args...)
+	if err != nil {
+		log.Println("error retrieving texts", err.Error())
+		return nil, err
+	}
+	defer res.Close()
+
+	payloads := make(map[int]Payload)
+	for res.Next() {
+		var msgID int
+		var t Text
+		err := res.Scan(&msgID, &t.Text)
+		if err != nil {
+			log.Println("error scanning texts", err.Error())
+			return nil, err
+		}
+		payloads[msgID] = t
+	}
+	return payloads, res.Err()
+}
+
+func (text) Delete(tx Execer, msgID int) error {
+	_, err := tx.Exec("DELETE FROM texts WHERE msg_id = ?", msgID)
+	return err
+}
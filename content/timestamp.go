@@ -0,0 +1,85 @@
+package content
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TimestampFormat is the format of the timestamps read from the database, in UTC they compare
+// as text
+const TimestampFormat = time.RFC3339
+
+// layouts of timestamps drivers return as text, SQLite stores CURRENT_TIMESTAMP as the second
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// timestamp scans a time column into dest in TimestampFormat. SQLite and PostgreSQL return the
+// timestamp columns as times, in the timezone of the session for PostgreSQL, other columns
+// holding times as text
+type timestamp struct {
+	dest *sql.NullString
+}
+
+// Timestamp scans a time column that can be NULL into dest
+func Timestamp(dest *sql.NullString) sql.Scanner {
+	return timestamp{dest}
+}
+
+func (ts timestamp) Scan(src interface{}) error {
+	var t time.Time
+	switch v := src.(type) {
+	case nil:
+		*ts.dest = sql.NullString{}
+		return nil
+	case time.Time:
+		t = v
+	case string, []byte:
+		text := fmt.Sprintf("%s", v)
+		parsed, err := parseTimestamp(text)
+		if err != nil {
+			return err
+		}
+		t = parsed
+	default:
+		return fmt.Errorf("cannot scan %T into a timestamp", src)
+	}
+	*ts.dest = sql.NullString{String: t.UTC().Format(TimestampFormat), Valid: true}
+	return nil
+}
+
+// RequiredTimestamp scans a NOT NULL time column into dest
+func RequiredTimestamp(dest *string) sql.Scanner {
+	return requiredTimestamp{dest}
+}
+
+type requiredTimestamp struct {
+	dest *string
+}
+
+func (ts requiredTimestamp) Scan(src interface{}) error {
+	var value sql.NullString
+	err := timestamp{&value}.Scan(src)
+	if err != nil {
+		return err
+	}
+	if !value.Valid {
+		return fmt.Errorf("cannot scan NULL into a timestamp")
+	}
+	*ts.dest = value.String
+	return nil
+}
+
+func parseTimestamp(text string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		t, err := time.Parse(layout, text)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse timestamp %q", text)
+}
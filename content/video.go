@@ -0,0 +1,185 @@
+package content
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	ErrorSourceNotSupported = "error video source not supported"
+	ErrorInvalidVideoUrl    = "error url is not a video of the source"
+)
+
+var errorSourceNotSupported = errors.New(ErrorSourceNotSupported)
+var errorInvalidVideoUrl = errors.New(ErrorInvalidVideoUrl)
+
+var youtubeID = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+var vimeoID = regexp.MustCompile(`^[0-9]+$`)
+
+// Video is the payload of video messages of a supported source, stored in videos with the
+// canonical url. The id is the one the source gives the video, the embed url is its player
+type Video struct {
+	Source   string `json:"source"`
+	Url      string `json:"url"`
+	VideoID  string `json:"video_id,omitempty"`
+	EmbedUrl string `json:"embed_url,omitempty"`
+}
+
+// parse the url of a video of source, urls of other sites or without a video id are rejected.
+// The video has the canonical url, whatever form of url it was sent with
+func ParseVideoUrl(source string, rawUrl string) (Video, error) {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return Video{}, errorInvalidVideoUrl
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	var id string
+	switch source {
+	case "youtube":
+		switch host {
+		case "youtube.com", "m.youtube.com", "music.youtube.com":
+			// youtube.com/watch?v=ID, youtube.com/embed/ID, youtube.com/shorts/ID, youtube.com/v/ID
+			if len(path) == 1 && path[0] == "watch" {
+				id = u.Query().Get("v")
+			} else if len(path) == 2 && (path[0] == "embed" || path[0] == "shorts" || path[0] == "v") {
+				id = path[1]
+			}
+		case "youtu.be":
+			if len(path) == 1 {
+				id = path[0]
+			}
+		case "youtube-nocookie.com":
+			if len(path) == 2 && path[0] == "embed" {
+				id = path[1]
+			}
+		}
+		if !youtubeID.MatchString(id) {
+			return Video{}, errorInvalidVideoUrl
+		}
+	case "vimeo":
+		switch host {
+		case "vimeo.com":
+			// vimeo.com/ID, vimeo.com/channels/NAME/ID, vimeo.com/groups/NAME/videos/ID
+			if len(path) == 1 {
+				id = path[0]
+			} else if len(path) == 3 && path[0] == "channels" {
+				id = path[2]
+			} else if len(path) == 4 && path[0] == "groups" && path[2] == "videos" {
+				id = path[3]
+			}
+		case "player.vimeo.com":
+			if len(path) == 2 && path[0] == "video" {
+				id = path[1]
+			}
+		}
+		if !vimeoID.MatchString(id) {
+			return Video{}, errorInvalidVideoUrl
+		}
+	default:
+		return Video{}, errorSourceNotSupported
+	}
+	return Video{Source: source, Url: videoUrl(source, id), VideoID: id, EmbedUrl: embedUrl(source, id)}, nil
+}
+
+// url a video is stored with
+func videoUrl(source string, id string) string {
+	switch source {
+	case "youtube":
+		return "https://www.youtube.com/watch?v=" + id
+	case "vimeo":
+		return "https://vimeo.com/" + id
+	}
+	return ""
+}
+
+// url of the player to embed in an iframe
+func embedUrl(source string, id string) string {
+	switch source {
+	case "youtube":
+		return "https://www.youtube.com/embed/" + id
+	case "vimeo":
+		return "https://player.vimeo.com/video/" + id
+	}
+	return ""
+}
+
+type video struct{}
+
+func init() {
+	Register(video{})
+}
+
+func (video) Name() string {
+	return "video"
+}
+
+func (video) Decode(data json.RawMessage) (Payload, error) {
+	var v Video
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+func (video) Validate(p Payload) (Payload, error) {
+	v, ok := p.(Video)
+	if !ok || v.Source == "" || v.Url == "" {
+		log.Println(errorMissingArgument)
+		return p, errorMissingArgument
+	}
+	if v.Source != "youtube" && v.Source != "vimeo" {
+		log.Println(errorSourceNotSupported)
+		return p, errorSourceNotSupported
+	}
+	parsed, err := ParseVideoUrl(v.Source, v.Url)
+	if err != nil {
+		log.Println(err)
+		return p, err
+	}
+	return parsed, nil
+}
+
+func (video) Insert(tx Execer, msgID int, p Payload) error {
+	v := p.(Video)
+	_, err := tx.Exec("INSERT INTO videos (msg_id, source, v_url) VALUES (?, ?, ?)", msgID, v.Source, v.Url)
+	if err != nil {
+		log.Println("error inserting video into videos table", err.Error())
+	}
+	return err
+}
+
+func (video) Load(q Querier, msgIDs []int) (map[int]Payload, error) {
+	list, args := inList(msgIDs)
+	res, err := q.Query("SELECT msg_id, source, v_url FROM videos WHERE msg_id IN "+list, args...)
+	if err != nil {
+		log.Println("error retrieving videos", err.Error())
+		return nil, err
+	}
+	defer res.Close()
+
+	payloads := make(map[int]Payload)
+	for res.Next() {
+		var msgID int
+		var v Video
+		err := res.Scan(&msgID, &v.Source, &v.Url)
+		if err != nil {
+			log.Println("error scanning videos", err.Error())
+			return nil, err
+		}
+		// videos stored before urls were checked may not parse
+		if parsed, err := ParseVideoUrl(v.Source, v.Url); err == nil {
+			v = parsed
+		}
+		payloads[msgID] = v
+	}
+	return payloads, res.Err()
+}
+
+func (video) Delete(tx Execer, msgID int) error {
+	_, err := tx.Exec("DELETE FROM videos WHERE msg_id = ?", msgID)
+	return err
+}
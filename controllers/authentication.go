@@ -1,34 +1,159 @@
 package controllers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/dtsang7/ASAPP/models"
 	"time"
 )
 
-func (h Handler) Authenticate(user models.User) (int, string, error) {
-	var tokenString string
+const (
+	ErrorInvalidToken = "error invalid or expired token"
+	ErrorRevokedToken = "error token has been revoked"
+	ErrorTokenStore   = "error checking token revocation"
+)
+
+var errorInvalidToken = errors.New(ErrorInvalidToken)
+var errorRevokedToken = errors.New(ErrorRevokedToken)
+var errorTokenStore = errors.New(ErrorTokenStore)
+
+const (
+	accessTokenType  = "access"
+	refreshTokenType = "refresh"
+	accessTokenTTL   = time.Minute * 15
+	refreshTokenTTL  = time.Hour * 24 * 30
+)
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+func (h Handler) Authenticate(user models.User) (int, TokenPair, error) {
 	id, err := h.DB.LoginUser(user)
 	if err != nil {
-		return 0, tokenString, err
+		return 0, TokenPair{}, err
+	}
+	tokens, err := createTokens(h.Keys, id)
+	if err != nil {
+		return 0, TokenPair{}, err
+	}
+	return id, tokens, nil
+}
+
+// exchange a refresh token for a new token pair, the old refresh token is revoked. Of
+// concurrent exchanges of a token only the one that revokes it gets a pair
+func (h Handler) Refresh(refreshToken string) (int, TokenPair, error) {
+	claims, err := h.parseToken(refreshToken, refreshTokenType)
+	if err != nil {
+		return 0, TokenPair{}, err
+	}
+	id, jti, exp := tokenIdentity(claims)
+	revoked, err := h.DB.RevokeToken(jti, exp)
+	if err != nil {
+		return 0, TokenPair{}, errorTokenStore
+	}
+	if !revoked {
+		return 0, TokenPair{}, errorRevokedToken
+	}
+	tokens, err := createTokens(h.Keys, id)
+	if err != nil {
+		return 0, TokenPair{}, err
 	}
-	tokenString, err = createToken(id)
+	return id, tokens, nil
+}
+
+// revoke token by its claims
+func (h Handler) Revoke(claims jwt.MapClaims) error {
+	_, jti, exp := tokenIdentity(claims)
+	_, err := h.DB.RevokeToken(jti, exp)
+	return err
+}
+
+// validate signature, expiry, type and revocation of a token string
+func (h Handler) parseToken(tokenString string, tokenType string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, h.Keys.ValidationKeyGetter)
+	if err != nil || !token.Valid || token.Method != jwt.SigningMethodHS256 {
+		return nil, errorInvalidToken
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	err = h.checkClaims(claims, tokenType)
 	if err != nil {
-		return 0, tokenString, err
+		return nil, err
 	}
-	return id, tokenString, nil
+	return claims, nil
 }
 
-// create jwt token
-func createToken(id int) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// check token type and that the token has not been revoked
+func (h Handler) checkClaims(claims jwt.MapClaims, tokenType string) error {
+	if typ, _ := claims["typ"].(string); typ != tokenType {
+		return errorInvalidToken
+	}
+	if _, found := claims["id"].(float64); !found {
+		return errorInvalidToken
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return errorInvalidToken
+	}
+	revoked, err := h.DB.IsTokenRevoked(jti)
+	if err != nil {
+		return errorTokenStore
+	}
+	if revoked {
+		return errorRevokedToken
+	}
+	return nil
+}
+
+// read user id, jti and expiry from validated claims
+func tokenIdentity(claims jwt.MapClaims) (int, string, time.Time) {
+	id, _ := claims["id"].(float64)
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	return int(id), jti, time.Unix(int64(exp), 0)
+}
+
+// create access and refresh jwt tokens signed with the active key
+func createTokens(keys *KeyRing, id int) (TokenPair, error) {
+	var tokens TokenPair
+	accessToken, err := createToken(keys, id, accessTokenType, accessTokenTTL)
+	if err != nil {
+		return tokens, err
+	}
+	refreshToken, err := createToken(keys, id, refreshTokenType, refreshTokenTTL)
+	if err != nil {
+		return tokens, err
+	}
+	return TokenPair{accessToken, refreshToken}, nil
+}
+
+// create jwt token with a unique jti so it can be revoked
+func createToken(keys *KeyRing, id int, tokenType string, ttl time.Duration) (string, error) {
+	jti, err := newRandomID()
+	if err != nil {
+		return "", err
+	}
+	tokenString, err := keys.Sign(jwt.MapClaims{
 		"id":  id,
-		"exp": time.Now().Add(time.Minute * 300).Unix(),
+		"jti": jti,
+		"typ": tokenType,
+		"exp": time.Now().Add(ttl).Unix(),
 	})
-	// This should be an actual secret
-	tokenString, err := token.SignedString([]byte("secret"))
 	if err != nil {
 		return "", err
 	}
 	return tokenString, nil
 }
+
+// random hex id, used as token jti and upload id
+func newRandomID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dtsang7/ASAPP/config"
+	"github.com/dtsang7/ASAPP/models"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// store whose revocation checks wait for each other, so concurrent refreshes all find the
+// token not revoked yet before any of them revokes it
+type racingStore struct {
+	models.Store
+	checked sync.WaitGroup
+}
+
+func (s *racingStore) IsTokenRevoked(jti string) (bool, error) {
+	revoked, err := s.Store.IsTokenRevoked(jti)
+	s.checked.Done()
+	s.checked.Wait()
+	return revoked, err
+}
+
+func TestRefreshReplayedConcurrently(t *testing.T) {
+	keys, err := NewKeyRing(config.Configuration{JWTSecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := createTokens(keys, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const replays = 5
+	store := &racingStore{Store: models.NewMemoryStore()}
+	store.checked.Add(replays)
+	h := Handler{DB: store, Keys: keys}
+	errs := make(chan error, replays)
+	for i := 0; i < replays; i++ {
+		go func() {
+			_, _, err := h.Refresh(tokens.RefreshToken)
+			errs <- err
+		}()
+	}
+
+	refreshed := 0
+	for i := 0; i < replays; i++ {
+		err := <-errs
+		if err == nil {
+			refreshed++
+		} else if err != errorRevokedToken {
+			t.Fatal(err)
+		}
+	}
+	if refreshed != 1 {
+		t.Fatalf("expected 1 refresh, got %d", refreshed)
+	}
+}
+
+// store whose revoked tokens can't be read or written
+type failingTokenStore struct {
+	models.Store
+	failCheck  bool
+	failRevoke bool
+}
+
+var errorStoreUnavailable = errors.New("error database is locked")
+
+func (s failingTokenStore) IsTokenRevoked(jti string) (bool, error) {
+	if s.failCheck {
+		return false, errorStoreUnavailable
+	}
+	return s.Store.IsTokenRevoked(jti)
+}
+
+func (s failingTokenStore) RevokeToken(jti string, expiresOn time.Time) (bool, error) {
+	if s.failRevoke {
+		return false, errorStoreUnavailable
+	}
+	return s.Store.RevokeToken(jti, expiresOn)
+}
+
+/*
+Test Scenario:
+1. A refresh failing to check whether the token is revoked is a server error
+2. A refresh failing to revoke the token is a server error, the client can retry with it
+*/
+func TestRefreshStoreError(t *testing.T) {
+	keys, err := NewKeyRing(config.Configuration{JWTSecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := createTokens(keys, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := fmt.Sprintf(`{"refresh_token": "%s"}`, tokens.RefreshToken)
+
+	stores := []failingTokenStore{
+		{Store: models.NewMemoryStore(), failCheck: true},
+		{Store: models.NewMemoryStore(), failRevoke: true},
+	}
+	for _, store := range stores {
+		h := Handler{DB: store, Keys: keys}
+		if _, _, err := h.Refresh(tokens.RefreshToken); err != errorTokenStore {
+			t.Fatalf("expected %v, got %v", errorTokenStore, err)
+		}
+		w := serveAs(h.RefreshHandler, 0, "POST", "/token/refresh", body)
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("expected %d, got %d %s", http.StatusInternalServerError, w.Code, w.Body)
+		}
+	}
+}
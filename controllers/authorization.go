@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"context"
+	"github.com/dgrijalva/jwt-go"
+	"log"
+	"net/http"
+)
+
+type contextKey string
+
+// request context key holding the authenticated user id
+const userIDKey contextKey = "userID"
+
+// Middleware run after the jwt middleware. Rejects revoked and refresh tokens and
+// stores the user id of the token in the request context for the handlers
+func (h Handler) Authorize(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	token, ok := r.Context().Value("user").(*jwt.Token)
+	if !ok {
+		http.Error(w, errorInvalidToken.Error(), http.StatusUnauthorized)
+		return
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	err := h.checkClaims(claims, accessTokenType)
+	if err != nil {
+		log.Println(err.Error())
+		writeTokenError(err, w)
+		return
+	}
+	id, _, _ := tokenIdentity(claims)
+	next(w, r.WithContext(context.WithValue(r.Context(), userIDKey, id)))
+}
+
+// write a token error, 401 Unauthorized unless the token could not be checked against the
+// revoked tokens
+func writeTokenError(err error, w http.ResponseWriter) {
+	if err == errorInvalidToken || err == errorRevokedToken {
+		WriteHttpStatusError(err, http.StatusUnauthorized, w)
+		return
+	}
+	WriteHttpStatusError(err, http.StatusInternalServerError, w)
+}
+
+// authenticated user id of the request, 0 outside of Authorize
+func UserID(r *http.Request) int {
+	id, _ := r.Context().Value(userIDKey).(int)
+	return id
+}
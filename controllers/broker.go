@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"log"
+	"sync"
+)
+
+// messages buffered per subscription before new ones are dropped
+const subscriptionBuffer = 64
+
+// Broker fans out newly stored messages to the live connections of a user
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[int]map[*Subscription]bool
+}
+
+// Subscription receives the messages published to one user until closed
+type Subscription struct {
+	UserID int
+	C      chan Message
+	broker *Broker
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[int]map[*Subscription]bool)}
+}
+
+// start receiving messages published to user
+func (b *Broker) Subscribe(userID int) *Subscription {
+	sub := &Subscription{UserID: userID, C: make(chan Message, subscriptionBuffer), broker: b}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[*Subscription]bool)
+	}
+	b.subscribers[userID][sub] = true
+	return sub
+}
+
+// deliver message to every subscription of user, a subscriber that
+// doesn't keep up misses the message and has to fetch it instead
+func (b *Broker) Publish(userID int, msg Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscribers[userID] {
+		select {
+		case sub.C <- msg:
+		default:
+			log.Println("dropping message for slow subscriber of user", userID)
+		}
+	}
+}
+
+// stop receiving messages and close the channel
+func (s *Subscription) Close() {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.subscribers[s.UserID][s] {
+		return
+	}
+	delete(b.subscribers[s.UserID], s)
+	if len(b.subscribers[s.UserID]) == 0 {
+		delete(b.subscribers, s.UserID)
+	}
+	close(s.C)
+}
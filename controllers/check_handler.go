@@ -11,8 +11,15 @@ type Health struct {
 }
 
 type Handler struct {
-	DB        *models.DAO
-	JWTSecret string
+	DB         models.Store
+	Keys       *KeyRing
+	Broker     *Broker
+	Thumbnails *Thumbnailer
+	Previews   *Previewer
+	UploadDir  string
+	Files      FileLimits
+	// most messages returned by a page of GET /messages
+	MaxPageSize int
 }
 
 // checks system health
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type GetConversationsRequest struct {
+	Limit  int
+	Offset int
+}
+
+type Conversation struct {
+	UserID      int            `json:"user"`
+	Username    string         `json:"username"`
+	LastMessage MessageContent `json:"last_message"`
+	Timestamp   string         `json:"timestamp"`
+	Unread      int            `json:"unread"`
+}
+
+type GetConversationsResponse struct {
+	Conversations []Conversation `json:"conversations"`
+}
+
+type GetConversationMessagesRequest struct {
+	UserID int
+	Before int
+	After  int
+	Limit  int
+}
+
+// Before and After are the cursors to pass to load older and newer messages
+type GetConversationMessagesResponse struct {
+	Messages []Message `json:"messages"`
+	Before   int       `json:"before,omitempty"`
+	After    int       `json:"after,omitempty"`
+}
+
+// lists the users the authenticated user exchanged messages with, most recent first
+func (h Handler) GetConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := ParseAndValidateGetConversationsRequest(r, h.MaxPageSize)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	dbConversations, err := h.DB.GetConversations(UserID(r), req.Limit, req.Offset)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	conversations := []Conversation{}
+	for _, dbConversation := range dbConversations {
+		lastMessage := toMessage(dbConversation.LastMessage)
+		conversations = append(conversations, Conversation{
+			UserID:      dbConversation.UserID,
+			Username:    dbConversation.Username,
+			LastMessage: lastMessage.Content,
+			Timestamp:   lastMessage.TimeStamp,
+			Unread:      dbConversation.Unread,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(GetConversationsResponse{conversations})
+	if err != nil {
+		http.Error(w, "Write error", http.StatusInternalServerError)
+	}
+}
+
+// returns the messages between the authenticated user and another user in both directions
+func (h Handler) GetConversationMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := ParseAndValidateGetConversationMessagesRequest(r, h.MaxPageSize)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	dbMsgs, err := h.DB.GetConversationMessages(UserID(r), req.UserID, req.Before, req.After, req.Limit)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	messages, err := h.toMessages(UserID(r), dbMsgs)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	resp := GetConversationMessagesResponse{Messages: messages}
+	h.markDelivered(UserID(r), resp.Messages)
+	if len(resp.Messages) > 0 {
+		resp.Before = resp.Messages[0].MsgID
+		resp.After = resp.Messages[len(resp.Messages)-1].MsgID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, "Write error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	ErrorInvalidCursor    = "error invalid cursor"
+	ErrorInvalidDirection = "error direction must be older or newer"
+)
+
+var errorInvalidCursor = errors.New(ErrorInvalidCursor)
+var errorInvalidDirection = errors.New(ErrorInvalidDirection)
+
+// directions of a page of GET /messages, from its start message
+const (
+	directionOlder = "older"
+	directionNewer = "newer"
+)
+
+// cursor is the recipient, direction and start message of a page, sent to clients as an
+// opaque string
+type cursor struct {
+	RecipientID int
+	Direction   string
+	StartMsgID  int
+}
+
+func (c cursor) String() string {
+	s := strconv.Itoa(c.RecipientID) + ":" + c.Direction + ":" + strconv.Itoa(c.StartMsgID)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// parse a cursor of the pages of recipientID, cursors of other recipients are invalid
+func parseCursor(s string, recipientID int) (cursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, errorInvalidCursor
+	}
+	parts := strings.SplitN(string(decoded), ":", 3)
+	if len(parts) != 3 || (parts[1] != directionOlder && parts[1] != directionNewer) {
+		return cursor{}, errorInvalidCursor
+	}
+	recipient, err := strconv.Atoi(parts[0])
+	if err != nil || recipient != recipientID {
+		return cursor{}, errorInvalidCursor
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil || id <= 0 {
+		return cursor{}, errorInvalidCursor
+	}
+	return cursor{recipient, parts[1], id}, nil
+}
+
+// cursors of the pages after a page of messages, oldest first. The next page starts after the
+// last message, or where the page started when it is empty, so it can be polled for new
+// messages. The previous page ends before the first message, it is left out when the page
+// is the first
+func pageCursors(req GetMessagesRequest, messages []Message, more bool) (next string, prev string) {
+	if len(messages) == 0 {
+		start := req.StartMsgID
+		if req.Direction == directionOlder {
+			start++
+		}
+		return cursor{req.RecipientID, directionNewer, start}.String(), ""
+	}
+	first, last := messages[0].MsgID, messages[len(messages)-1].MsgID
+	next = cursor{req.RecipientID, directionNewer, last + 1}.String()
+	if first > 1 && (req.Direction == directionNewer || more) {
+		prev = cursor{req.RecipientID, directionOlder, first - 1}.String()
+	}
+	return next, prev
+}
@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dtsang7/ASAPP/models"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+const (
+	ErrorNotGroupMember   = "error not a member of the group"
+	ErrorNotGroupOwner    = "error only the group owner can remove other members"
+	ErrorOwnerCannotLeave = "error the group owner can't leave the group"
+)
+
+var errorNotGroupMember = errors.New(ErrorNotGroupMember)
+var errorNotGroupOwner = errors.New(ErrorNotGroupOwner)
+var errorOwnerCannotLeave = errors.New(ErrorOwnerCannotLeave)
+
+type CreateGroupRequest struct {
+	Name    string `json:"name"`
+	Members []int  `json:"members"`
+}
+
+type CreateGroupResponse struct {
+	Id int
+}
+
+type AddGroupMemberRequest struct {
+	UserID int `json:"user"`
+}
+
+type GroupMember struct {
+	UserID   int    `json:"id"`
+	Username string `json:"username"`
+	JoinedOn string `json:"joined_on"`
+}
+
+type GetGroupMembersResponse struct {
+	Members []GroupMember `json:"members"`
+}
+
+type GetGroupMessagesRequest struct {
+	GroupID    int
+	StartMsgID int
+	Limit      int
+}
+
+// creates a group owned by the authenticated user
+func (h Handler) CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateGroupRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	err := ValidateCreateGroup(req)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	id, err := h.DB.CreateGroup(UserID(r), req.Name, req.Members)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(CreateGroupResponse{id})
+	if err != nil {
+		http.Error(w, "Write error", http.StatusInternalServerError)
+	}
+}
+
+// lists the members of a group the authenticated user belongs to
+func (h Handler) GetGroupMembersHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, err := parseGroupID(r)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	dbMembers, err := h.groupMembers(groupID, UserID(r))
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	members := []GroupMember{}
+	for _, dbMember := range dbMembers {
+		members = append(members, GroupMember{dbMember.UserID, dbMember.Username, dbMember.JoinedOn})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(GetGroupMembersResponse{members})
+	if err != nil {
+		http.Error(w, "Write error", http.StatusInternalServerError)
+	}
+}
+
+// any member can add users to the group
+func (h Handler) AddGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	var req AddGroupMemberRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	groupID, err := parseGroupID(r)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	if req.UserID <= 0 {
+		WriteHttpError(errorMissingArgument, w)
+		return
+	}
+	err = h.requireGroupMember(groupID, UserID(r))
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	err = h.DB.AddGroupMember(groupID, req.UserID)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// members can leave the group, only the owner can remove other members
+func (h Handler) RemoveGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, err := parseGroupID(r)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	memberID, err := parsePositiveInt(mux.Vars(r)["userId"])
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	userID := UserID(r)
+	err = h.requireGroupMember(groupID, userID)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	if memberID != userID {
+		group, err := h.DB.GetGroup(groupID)
+		if err != nil {
+			WriteHttpError(err, w)
+			return
+		}
+		if group.OwnerID != userID {
+			WriteHttpError(errorNotGroupOwner, w)
+			return
+		}
+	}
+
+	err = h.DB.RemoveGroupMember(groupID, memberID)
+	if err != nil && err.Error() == models.ErrorOwnerCannotLeave {
+		err = errorOwnerCannotLeave
+	}
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sends a message to a group, the group is taken from the path
+func (h Handler) SendGroupMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var req Message
+	json.NewDecoder(r.Body).Decode(&req)
+
+	groupID, err := parseGroupID(r)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	req.GroupID = groupID
+
+	msg, err := h.sendMessage(UserID(r), req)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	resp := SendMessageResponse{msg.MsgID, msg.TimeStamp}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, "Write error", http.StatusInternalServerError)
+	}
+}
+
+// returns the messages of a group the authenticated user belongs to
+func (h Handler) GetGroupMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := ParseAndValidateGetGroupMessagesRequest(r, h.MaxPageSize)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	err = h.requireGroupMember(req.GroupID, UserID(r))
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	dbMsgs, err := h.DB.GetGroupMessages(req.GroupID, req.StartMsgID, req.Limit)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	messages, err := h.toMessages(UserID(r), dbMsgs)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(GetMessagesResponse{Messages: messages})
+	if err != nil {
+		http.Error(w, "Write error", http.StatusInternalServerError)
+	}
+}
+
+// fails unless uid is a member of the group
+func (h Handler) requireGroupMember(groupID int, uid int) error {
+	member, err := h.DB.IsGroupMember(groupID, uid)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return errorNotGroupMember
+	}
+	return nil
+}
+
+// get the members of a group, fails unless uid is one of them
+func (h Handler) groupMembers(groupID int, uid int) ([]models.GroupMember, error) {
+	members, err := h.DB.GetGroupMembers(groupID)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if member.UserID == uid {
+			return members, nil
+		}
+	}
+	return nil, errorNotGroupMember
+}
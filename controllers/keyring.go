@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/dtsang7/ASAPP/config"
+)
+
+const (
+	ErrorInvalidJWTKey = "error jwt key requires kid and secret"
+	ErrorNoSigningKey  = "error no active jwt signing key"
+	ErrorUnknownKeyID  = "error unknown or retired jwt key id"
+)
+
+var errorInvalidJWTKey = errors.New(ErrorInvalidJWTKey)
+var errorNoSigningKey = errors.New(ErrorNoSigningKey)
+var errorUnknownKeyID = errors.New(ErrorUnknownKeyID)
+
+// kid used when the config only provides the legacy jwt_secret
+const defaultKid = "default"
+
+// KeyRing holds the secrets used for jwt tokens. New tokens are signed with the
+// active key, tokens signed with any key that is not retired are still accepted
+type KeyRing struct {
+	activeKid string
+	keys      map[string]config.JWTKey
+}
+
+// build key ring from config
+func NewKeyRing(conf config.Configuration) (*KeyRing, error) {
+	keys := conf.JWTKeys
+	activeKid := conf.JWTActiveKid
+	if len(keys) == 0 && conf.JWTSecret != "" {
+		keys = []config.JWTKey{{Kid: defaultKid, Secret: conf.JWTSecret}}
+		activeKid = defaultKid
+	}
+
+	ring := &KeyRing{activeKid: activeKid, keys: make(map[string]config.JWTKey)}
+	for _, key := range keys {
+		if key.Kid == "" || key.Secret == "" {
+			return nil, errorInvalidJWTKey
+		}
+		ring.keys[key.Kid] = key
+	}
+
+	active, found := ring.keys[activeKid]
+	if !found || active.Retired {
+		return nil, errorNoSigningKey
+	}
+	return ring, nil
+}
+
+// sign claims with the active key
+func (k *KeyRing) Sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = k.activeKid
+	return token.SignedString([]byte(k.keys[k.activeKid].Secret))
+}
+
+// find the secret matching the kid header of a token, used by the jwt middleware
+func (k *KeyRing) ValidationKeyGetter(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, found := k.keys[kid]
+	if !found || key.Retired {
+		return nil, errorUnknownKeyID
+	}
+	return []byte(key.Secret), nil
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dtsang7/ASAPP/models"
+	"log"
+	"net/http"
+)
+
+const (
+	ErrorNotMessageSender = "error only the sender can change a message"
+	ErrorMessageDeleted   = "error message has been deleted"
+	ErrorEditNotSupported = "error the content of this type of message can't be edited"
+)
+
+var errorNotMessageSender = errors.New(ErrorNotMessageSender)
+var errorMessageDeleted = errors.New(ErrorMessageDeleted)
+var errorEditNotSupported = errors.New(ErrorEditNotSupported)
+
+// content replacing the content of the message, it must keep the same type
+type EditMessageRequest struct {
+	Content MessageContent `json:"content"`
+}
+
+// edits the content of a message sent by the authenticated user, returns the edited message
+func (h Handler) EditMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var req EditMessageRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	msgID, err := parseMsgID(r)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	dbMsg, err := h.findOwnMessage(msgID, UserID(r))
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	c, err := ValidateEditMessage(req, dbMsg.Type)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	err = h.DB.EditMessage(msgID, c)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	dbMsg, err = h.DB.GetMessage(msgID)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	if dbMsg.Type == "text" {
+		h.Previews.Enqueue(dbMsg)
+	}
+
+	// the edit is stored, live connections that miss it get it with the next fetch
+	msg := toMessage(dbMsg)
+	var members []models.GroupMember
+	if msg.GroupID > 0 {
+		members, err = h.DB.GetGroupMembers(msg.GroupID)
+		if err != nil {
+			log.Println("error retrieving group members of edited message", err.Error())
+		}
+	}
+	h.publish(msg, members)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(msg)
+	if err != nil {
+		http.Error(w, "Write error", http.StatusInternalServerError)
+	}
+}
+
+// deletes a message sent by the authenticated user, the message is kept as a tombstone
+func (h Handler) DeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	msgID, err := parseMsgID(r)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	_, err = h.findOwnMessage(msgID, UserID(r))
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	err = h.DB.DeleteMessage(msgID)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	h.Thumbnails.Remove(msgID)
+	w.WriteHeader(http.StatusNoContent)
+}
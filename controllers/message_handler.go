@@ -1,45 +1,68 @@
 package controllers
 
 import (
-	"database/sql"
 	"encoding/json"
 	"errors"
-	"github.com/dgrijalva/jwt-go"
+	"github.com/dtsang7/ASAPP/content"
 	"github.com/dtsang7/ASAPP/models"
+	"log"
 	"net/http"
+	"time"
 )
 
 const (
 	ErrorMismatchIDMessage = "ID in token doesn't match sender_id. Stop pretending to be someone else :("
+	ErrorMessageNotFound   = "error message not found"
 )
 
 var errorMismatchIDMessage = errors.New(ErrorMismatchIDMessage)
+var errorMessageNotFound = errors.New(ErrorMessageNotFound)
 
+// longest text of a quoted message preview, in characters
+const maxQuoteLength = 100
+
+// a page of messages starting at StartMsgID, included, in Direction. An older page without
+// StartMsgID ends at the newest message
 type GetMessagesRequest struct {
 	RecipientID int `json:"recipient"`
 	StartMsgID  int `json:"start"`
+	Direction   string
 	Limit       int
+	Wait        time.Duration
 }
 
+// messages are oldest first, the cursors load the next, newer, and the previous, older, page
 type GetMessagesResponse struct {
-	Messages []Message `json:"messages"`
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	PrevCursor string    `json:"prev_cursor,omitempty"`
 }
 
-type MessageContent struct {
-	Type   string `json:"type"`
-	Text   string `json:"text,omitempty"`
-	Width  int    `json:"width,omitempty"`
-	Height int    `json:"height,omitempty"`
-	Source string `json:"source,omitempty"`
-	Url    string `json:"url,omitempty"`
-}
+// content of a message, the fields set depend on its type
+type MessageContent = content.Content
 
 type Message struct {
 	MsgID       int            `json:"id"`
 	TimeStamp   string         `json:"timestamp"`
 	SenderID    int            `json:"sender"`
-	RecipientID int            `json:"recipient"`
+	RecipientID int            `json:"recipient,omitempty"`
+	GroupID     int            `json:"group,omitempty"`
+	ReplyTo     int            `json:"reply_to,omitempty"`
+	Quote       *Quote         `json:"quote,omitempty"`
 	Content     MessageContent `json:"content"`
+	DeliveredAt string         `json:"delivered_at,omitempty"`
+	ReadAt      string         `json:"read_at,omitempty"`
+	EditedAt    string         `json:"edited_at,omitempty"`
+	DeletedAt   string         `json:"deleted_at,omitempty"`
+	Reactions   []Reaction     `json:"reactions,omitempty"`
+}
+
+// preview of the message a reply quotes
+type Quote struct {
+	MsgID     int            `json:"id"`
+	TimeStamp string         `json:"timestamp"`
+	SenderID  int            `json:"sender"`
+	Content   MessageContent `json:"content"`
 }
 
 type SendMessageResponse struct {
@@ -47,119 +70,378 @@ type SendMessageResponse struct {
 	Timestamp string
 }
 
-// verify user id in the toke matches user id in the request body
-func verifyTokenID(r *http.Request, id int) bool {
-	user := r.Context().Value("user")
-	tokenID, found := user.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
-	if !found {
-		return false
-	}
-	if id, ok := tokenID.(float64); !ok || id != float64(id) {
-		return false
-	}
-	return true
+// marks messages up to MsgID as read, only those from SenderID when set
+type MarkReadRequest struct {
+	MsgID    int `json:"msg_id"`
+	SenderID int `json:"sender"`
+}
+
+type MarkReadResponse struct {
+	Updated int `json:"updated"`
 }
 
+// sender is implied by the token, a different sender in the body is rejected
 func (h Handler) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 	var req Message
 	json.NewDecoder(r.Body).Decode(&req)
 
-	err := ValidateSendMessage(req)
+	msg, err := h.sendMessage(UserID(r), req)
 	if err != nil {
 		WriteHttpError(err, w)
 		return
 	}
-	if !verifyTokenID(r, req.SenderID) {
-		WriteHttpError(errorMismatchIDMessage, w)
-		return
+
+	resp := SendMessageResponse{msg.MsgID, msg.TimeStamp}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, "Write error", http.StatusInternalServerError)
+	}
+}
+
+// validate and store a message from sender, then publish it to the live connections
+// of the recipient or of the other group members
+func (h Handler) sendMessage(senderID int, req Message) (Message, error) {
+	if req.SenderID != 0 && req.SenderID != senderID {
+		return Message{}, errorMismatchIDMessage
+	}
+	req.SenderID = senderID
+
+	if uploadID := uploadOf(req.Content); uploadID != "" {
+		content, err := h.uploadContent(senderID, req.Content.Type, uploadID)
+		if err != nil {
+			return Message{}, err
+		}
+		req.Content = content
+	}
+	err := ValidateSendMessage(&req, h.findMessage)
+	if err != nil {
+		return Message{}, err
+	}
+	var members []models.GroupMember
+	if req.GroupID > 0 {
+		members, err = h.groupMembers(req.GroupID, senderID)
+		if err != nil {
+			return Message{}, err
+		}
 	}
 	dbMsg := models.Message{
 		SenderID:    req.SenderID,
 		RecipientID: req.RecipientID,
+		GroupID:     req.GroupID,
+		ReplyTo:     req.ReplyTo,
+		Type:        req.Content.Type,
+		Content:     req.Content,
 	}
-	switch req.Content.Type {
-	case "text":
-		dbMsg.Type = "text"
-		dbMsg.Message = sql.NullString{String: req.Content.Text, Valid: true}
+	dbMsg.MsgID, dbMsg.TimeStamp, err = h.DB.SendMessage(dbMsg)
+	if err != nil {
+		return Message{}, err
+	}
+
+	switch dbMsg.Type {
 	case "image":
-		dbMsg.Type = "image"
-		dbMsg.Url = sql.NullString{String: req.Content.Url, Valid: true}
-		dbMsg.Width = sql.NullInt64{Int64: int64(req.Content.Width), Valid: true}
-		dbMsg.Height = sql.NullInt64{Int64: int64(req.Content.Height), Valid: true}
-	case "video":
-		dbMsg.Type = "video"
-		dbMsg.Url = sql.NullString{String: req.Content.Url, Valid: true}
-		dbMsg.Source = sql.NullString{String: req.Content.Source, Valid: true}
-	default:
-		WriteHttpError(errors.New("Unsupported Message content type"), w)
+		h.Thumbnails.Enqueue(dbMsg)
+	case "text":
+		h.Previews.Enqueue(dbMsg)
+	}
+	msg := toMessage(dbMsg)
+	if msg.ReplyTo > 0 {
+		// the message is stored, without the quote it is still delivered with reply_to
+		quoted, err := h.DB.GetMessage(msg.ReplyTo)
+		if err == nil {
+			msg.Quote = toQuote(quoted)
+		}
+	}
+	h.publish(msg, members)
+	return msg, nil
+}
+
+// publish msg to the live connections of its recipient, or of the members of its group
+// but the sender
+func (h Handler) publish(msg Message, members []models.GroupMember) {
+	if msg.GroupID > 0 {
+		for _, member := range members {
+			if member.UserID != msg.SenderID {
+				h.Broker.Publish(member.UserID, msg)
+			}
+		}
+	} else {
+		h.Broker.Publish(msg.RecipientID, msg)
+	}
+}
+
+// only messages the authenticated user sent or received are returned. With wait set a
+// request for newer messages is held open until a matching message arrives or the wait expires
+func (h Handler) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	// Get query paramters
+	req, err := ParseAndValidateGetMessageRequest(r, h.MaxPageSize)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	var dbMsgs []models.Message
+	more := false
+	if req.Direction == directionOlder {
+		before := 0
+		if req.StartMsgID > 0 {
+			before = req.StartMsgID + 1
+		}
+		// one message more than the page tells if there are older ones
+		dbMsgs, err = h.DB.GetMessagesBefore(UserID(r), req.RecipientID, before, req.Limit+1)
+		if len(dbMsgs) > req.Limit {
+			dbMsgs, more = dbMsgs[1:], true
+		}
+	} else {
+		// subscribe before querying so a message stored in between still wakes us up
+		var sub *Subscription
+		if req.Wait > 0 {
+			sub = h.Broker.Subscribe(req.RecipientID)
+			defer sub.Close()
+		}
+		dbMsgs, err = h.DB.GetMessages(UserID(r), req.RecipientID, req.StartMsgID, req.Limit)
+		if err == nil && len(dbMsgs) == 0 && sub != nil {
+			dbMsgs, err = h.waitForMessages(r, sub, req)
+		}
+	}
+	if err != nil {
+		WriteHttpError(err, w)
 		return
 	}
-	msgID, timeStamp, err := h.DB.SendMessage(dbMsg)
+	messages, err := h.toMessages(UserID(r), dbMsgs)
 	if err != nil {
 		WriteHttpError(err, w)
 		return
 	}
+	h.markDelivered(UserID(r), messages)
+	resp := GetMessagesResponse{Messages: messages}
+	resp.NextCursor, resp.PrevCursor = pageCursors(req, messages, more)
 
-	resp := SendMessageResponse{msgID, timeStamp}
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(resp)
-	if err != nil {
+	jsonErr := json.NewEncoder(w).Encode(resp)
+	if jsonErr != nil {
 		http.Error(w, "Write error", http.StatusInternalServerError)
 	}
 }
 
-func (h Handler) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
-	// Get query paramters
-	req, err := ParseAndValidateGetMessageRequest(r)
+// wait for a message published to the recipient that the user can read, then query again
+func (h Handler) waitForMessages(r *http.Request, sub *Subscription, req GetMessagesRequest) ([]models.Message, error) {
+	viewerID := UserID(r)
+	timer := time.NewTimer(req.Wait)
+	defer timer.Stop()
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return nil, nil
+			}
+			if msg.MsgID < req.StartMsgID || (msg.SenderID != viewerID && msg.RecipientID != viewerID) {
+				continue
+			}
+			dbMsgs, err := h.DB.GetMessages(viewerID, req.RecipientID, req.StartMsgID, req.Limit)
+			if err != nil || len(dbMsgs) > 0 {
+				return dbMsgs, err
+			}
+		case <-timer.C:
+			return nil, nil
+		case <-r.Context().Done():
+			return nil, nil
+		}
+	}
+}
+
+// marks messages up to a msg_id received by the authenticated user as read
+func (h Handler) MarkReadHandler(w http.ResponseWriter, r *http.Request) {
+	var req MarkReadRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	err := ValidateMarkRead(req)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	updated, err := h.DB.MarkRead(UserID(r), req.MsgID, req.SenderID)
 	if err != nil {
 		WriteHttpError(err, w)
 		return
 	}
 
-	if !verifyTokenID(r, req.RecipientID) {
-		WriteHttpError(errorMismatchIDMessage, w)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(MarkReadResponse{updated})
+	if err != nil {
+		http.Error(w, "Write error", http.StatusInternalServerError)
+	}
+}
+
+// mark the messages received by user as delivered and set when they were, a failure only
+// delays the delivered tick
+func (h Handler) markDelivered(userID int, messages []Message) {
+	var ids []int
+	for _, msg := range messages {
+		if msg.RecipientID == userID && msg.DeliveredAt == "" {
+			ids = append(ids, msg.MsgID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	err := h.DB.MarkDelivered(userID, ids)
+	if err != nil {
+		log.Println("error marking messages delivered", err.Error())
 		return
 	}
+	deliveredAt := time.Now().UTC().Format(content.TimestampFormat)
+	for i := range messages {
+		if messages[i].RecipientID == userID && messages[i].DeliveredAt == "" {
+			messages[i].DeliveredAt = deliveredAt
+		}
+	}
+}
+
+// find a message the user can see, other messages are reported as not found
+func (h Handler) findMessage(msgID int, uid int) (models.Message, error) {
+	dbMsg, err := h.DB.GetMessage(msgID)
+	if err != nil && err.Error() == models.ErrorMessageDoesNotExist {
+		return dbMsg, errorMessageNotFound
+	}
+	if err != nil {
+		return dbMsg, err
+	}
+	if dbMsg.SenderID == uid || dbMsg.RecipientID == uid {
+		return dbMsg, nil
+	}
+	if dbMsg.GroupID > 0 {
+		member, err := h.DB.IsGroupMember(dbMsg.GroupID, uid)
+		if err != nil {
+			return dbMsg, err
+		}
+		if member {
+			return dbMsg, nil
+		}
+	}
+	return models.Message{}, errorMessageNotFound
+}
+
+// find a message sent by the user that has not been deleted
+func (h Handler) findOwnMessage(msgID int, uid int) (models.Message, error) {
+	dbMsg, err := h.findMessage(msgID, uid)
+	if err != nil {
+		return dbMsg, err
+	}
+	if dbMsg.SenderID != uid {
+		return dbMsg, errorNotMessageSender
+	}
+	if dbMsg.DeletedAt.Valid {
+		return dbMsg, errorMessageDeleted
+	}
+	return dbMsg, nil
+}
 
-	dbMsgs, err := h.DB.GetMessages(req.RecipientID, req.StartMsgID, req.Limit)
-	var messages []Message
+// convert stored messages to their response shape with the reactions seen by viewer, the
+// quoted messages and the thumbnails of images. A reply quotes a message of its own
+// conversation, so whoever can see the reply can see the quote
+func (h Handler) toMessages(viewerID int, dbMsgs []models.Message) ([]Message, error) {
+	messages := []Message{}
+	var ids []int
+	var replyIDs []int
+	var imageIDs []int
+	var textIDs []int
 	for _, dbMsg := range dbMsgs {
-		msg := Message{
-			MsgID:       dbMsg.MsgID,
-			TimeStamp:   dbMsg.TimeStamp,
-			SenderID:    dbMsg.SenderID,
-			RecipientID: dbMsg.RecipientID,
+		msg := toMessage(dbMsg)
+		messages = append(messages, msg)
+		ids = append(ids, msg.MsgID)
+		if msg.ReplyTo > 0 {
+			replyIDs = append(replyIDs, msg.ReplyTo)
 		}
-		switch dbMsg.Type {
-		case "text":
-			msg.Content = MessageContent{
-				Type: "text",
-				Text: dbMsg.Message.String,
-			}
+		switch msg.Content.Type {
 		case "image":
-			msg.Content = MessageContent{
-				Type:   "image",
-				Width:  int(dbMsg.Width.Int64),
-				Height: int(dbMsg.Height.Int64),
-				Url:    dbMsg.Url.String,
+			imageIDs = append(imageIDs, msg.MsgID)
+		case "text":
+			textIDs = append(textIDs, msg.MsgID)
+		}
+	}
+	reactions, err := h.DB.GetReactions(viewerID, ids)
+	if err != nil {
+		return nil, err
+	}
+	quoted, err := h.DB.GetMessagesByID(replyIDs)
+	if err != nil {
+		return nil, err
+	}
+	thumbnails, err := h.DB.GetThumbnails(imageIDs)
+	if err != nil {
+		return nil, err
+	}
+	previews, err := h.DB.GetLinkPreviews(textIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		for _, reaction := range reactions[messages[i].MsgID] {
+			messages[i].Reactions = append(messages[i].Reactions, Reaction{reaction.Emoji, reaction.Count, reaction.ReactedByMe})
+		}
+		if dbQuoted, found := quoted[messages[i].ReplyTo]; found {
+			messages[i].Quote = toQuote(dbQuoted)
+		}
+		switch p := messages[i].Content.Payload.(type) {
+		case content.Image:
+			for _, thumbnail := range thumbnails[messages[i].MsgID] {
+				switch thumbnail.Size {
+				case thumbnailSizes[0]:
+					p.ThumbnailUrl = thumbnailUrl(thumbnail.MsgID, thumbnail.Size)
+				case thumbnailSizes[1]:
+					p.PreviewUrl = thumbnailUrl(thumbnail.MsgID, thumbnail.Size)
+				}
 			}
-		case "video":
-			msg.Content = MessageContent{
-				Type:   "video",
-				Url:    dbMsg.Url.String,
-				Source: dbMsg.Source.String,
+			messages[i].Content.Payload = p
+		case content.Text:
+			// a preview fetched before an edit removed its link is not shown
+			if preview, found := previews[messages[i].MsgID]; found && firstLink(p.Text) == preview.Url {
+				p.LinkPreview = &content.LinkPreview{
+					Url:         preview.Url,
+					Title:       preview.Title,
+					Description: preview.Description,
+					ImageUrl:    preview.ImageUrl,
+				}
 			}
+			messages[i].Content.Payload = p
 		}
-		messages = append(messages, msg)
 	}
+	return messages, nil
+}
 
-	if err != nil {
-		WriteHttpError(err, w)
-		return
+// preview of a quoted message, long texts are cut to maxQuoteLength characters
+func toQuote(dbMsg models.Message) *Quote {
+	msg := toMessage(dbMsg)
+	if t, ok := msg.Content.Payload.(content.Text); ok {
+		text := []rune(t.Text)
+		if len(text) > maxQuoteLength {
+			t.Text = string(text[:maxQuoteLength]) + "…"
+			msg.Content.Payload = t
+		}
 	}
-	w.Header().Set("Content-Type", "application/json")
-	jsonErr := json.NewEncoder(w).Encode(GetMessagesResponse{messages})
-	if jsonErr != nil {
-		http.Error(w, "Write error", http.StatusInternalServerError)
+	return &Quote{msg.MsgID, msg.TimeStamp, msg.SenderID, msg.Content}
+}
+
+// convert a stored message to its response shape, deleted messages become "deleted" placeholders
+func toMessage(dbMsg models.Message) Message {
+	msg := Message{
+		MsgID:       dbMsg.MsgID,
+		TimeStamp:   dbMsg.TimeStamp,
+		SenderID:    dbMsg.SenderID,
+		RecipientID: dbMsg.RecipientID,
+		GroupID:     dbMsg.GroupID,
+		DeliveredAt: dbMsg.DeliveredAt.String,
+		ReadAt:      dbMsg.ReadAt.String,
+		EditedAt:    dbMsg.EditedAt.String,
+		DeletedAt:   dbMsg.DeletedAt.String,
+	}
+	if dbMsg.DeletedAt.Valid {
+		msg.Content = MessageContent{Type: "deleted"}
+		return msg
 	}
+	msg.ReplyTo = dbMsg.ReplyTo
+	msg.Content = dbMsg.Content
+	return msg
 }
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dtsang7/ASAPP/content"
+	"github.com/dtsang7/ASAPP/linkpreview"
+	"github.com/dtsang7/ASAPP/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// call handler as user uid, the way Authorize passes the user to the handlers
+func serveAs(handler http.HandlerFunc, uid int, method string, url string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), userIDKey, uid))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+/*
+Test Scenario:
+1. Handlers run on the in-memory store without a database or a server
+2. Alice sends a text to bob, bob's live connection receives it
+3. Bob fetches the message, it is marked delivered, carol gets nothing from bob's conversation
+*/
+func TestMessageHandlersMemoryStore(t *testing.T) {
+	store := models.NewMemoryStore()
+	h := Handler{
+		DB:          store,
+		Broker:      NewBroker(),
+		Previews:    NewPreviewer(store, linkpreview.NewFake()),
+		MaxPageSize: 100,
+	}
+	alice, _ := store.CreateUser(models.User{Username: "alice", Password: "test_password"})
+	bob, _ := store.CreateUser(models.User{Username: "bob", Password: "test_password"})
+	carol, _ := store.CreateUser(models.User{Username: "carol", Password: "test_password"})
+	sub := h.Broker.Subscribe(bob)
+	defer sub.Close()
+
+	w := serveAs(h.SendMessageHandler, alice, "POST", "/messages", fmt.Sprintf(`{"recipient": %d, "content": {"type": "text", "text": "hi bob"}}`, bob))
+	if w.Code != http.StatusOK {
+		t.Fatalf("send returned %d %s", w.Code, w.Body)
+	}
+	var sr SendMessageResponse
+	json.NewDecoder(w.Body).Decode(&sr)
+	select {
+	case msg := <-sub.C:
+		if msg.MsgID != sr.Id || msg.SenderID != alice {
+			t.Fatalf("unexpected pushed message %v", msg)
+		}
+	default:
+		t.Fatal("message not pushed to the recipient")
+	}
+
+	w = serveAs(h.GetMessagesHandler, bob, "GET", fmt.Sprintf("/messages?recipient=%d&start=%d", bob, sr.Id), "")
+	var gr GetMessagesResponse
+	json.NewDecoder(w.Body).Decode(&gr)
+	if w.Code != http.StatusOK || len(gr.Messages) != 1 || gr.Messages[0].MsgID != sr.Id {
+		t.Fatalf("unexpected messages %d %v", w.Code, gr.Messages)
+	}
+	if text, _ := gr.Messages[0].Content.Payload.(content.Text); text.Text != "hi bob" || gr.Messages[0].DeliveredAt == "" {
+		t.Fatalf("unexpected message %v", gr.Messages[0])
+	}
+	if msg, _ := store.GetMessage(sr.Id); !msg.DeliveredAt.Valid {
+		t.Fatal("fetched message not marked delivered")
+	}
+
+	w = serveAs(h.GetMessagesHandler, carol, "GET", fmt.Sprintf("/messages?recipient=%d&start=%d", bob, sr.Id), "")
+	gr = GetMessagesResponse{}
+	json.NewDecoder(w.Body).Decode(&gr)
+	if w.Code != http.StatusOK || len(gr.Messages) != 0 {
+		t.Fatalf("messages shown to a third user %d %v", w.Code, gr.Messages)
+	}
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"github.com/dtsang7/ASAPP/content"
+	"github.com/dtsang7/ASAPP/linkpreview"
+	"github.com/dtsang7/ASAPP/models"
+	"log"
+	"regexp"
+	"strings"
+)
+
+const (
+	previewWorkers = 2
+	previewQueue   = 256
+)
+
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// Previewer fetches the link previews of text messages in the background, through a
+// Fetcher so tests don't reach the network
+type Previewer struct {
+	DB      models.Store
+	Fetcher linkpreview.Fetcher
+	jobs    chan models.Message
+}
+
+func NewPreviewer(store models.Store, fetcher linkpreview.Fetcher) *Previewer {
+	p := &Previewer{
+		DB:      store,
+		Fetcher: fetcher,
+		jobs:    make(chan models.Message, previewQueue),
+	}
+	for i := 0; i < previewWorkers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// queue a text message with a link, when the queue is full the message has no preview
+func (p *Previewer) Enqueue(msg models.Message) {
+	if firstLink(messageText(msg)) == "" {
+		return
+	}
+	select {
+	case p.jobs <- msg:
+	default:
+		log.Println("dropping link preview of message", msg.MsgID)
+	}
+}
+
+func (p *Previewer) work() {
+	for msg := range p.jobs {
+		url := firstLink(messageText(msg))
+		preview, err := p.Fetcher.Fetch(url)
+		if err == nil {
+			err = p.DB.SetLinkPreview(models.LinkPreview{
+				MsgID:       msg.MsgID,
+				Url:         url,
+				Title:       preview.Title,
+				Description: preview.Description,
+				ImageUrl:    preview.ImageUrl,
+			})
+		}
+		if err != nil {
+			log.Println("error making link preview of message", msg.MsgID, err.Error())
+		}
+	}
+}
+
+// text of a text message, empty for other messages
+func messageText(msg models.Message) string {
+	t, _ := msg.Content.Payload.(content.Text)
+	return t.Text
+}
+
+// first http link of a text, without the punctuation ending the sentence around it
+func firstLink(text string) string {
+	return strings.TrimRight(linkPattern.FindString(text), ".,;:!?)]}'")
+}
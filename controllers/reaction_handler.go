@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+type AddReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+// users who reacted to a message with the same emoji
+type Reaction struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+// adds a reaction of the authenticated user to a message they can see
+func (h Handler) AddReactionHandler(w http.ResponseWriter, r *http.Request) {
+	var req AddReactionRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	msgID, err := parseMsgID(r)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	err = ValidateReaction(req.Emoji)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	dbMsg, err := h.findMessage(msgID, UserID(r))
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	if dbMsg.DeletedAt.Valid {
+		WriteHttpError(errorMessageDeleted, w)
+		return
+	}
+
+	err = h.DB.AddReaction(msgID, UserID(r), req.Emoji)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removes a reaction of the authenticated user from a message they can see
+func (h Handler) RemoveReactionHandler(w http.ResponseWriter, r *http.Request) {
+	msgID, err := parseMsgID(r)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	emoji := mux.Vars(r)["emoji"]
+	err = ValidateReaction(emoji)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	_, err = h.findMessage(msgID, UserID(r))
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	err = h.DB.RemoveReaction(msgID, UserID(r), emoji)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/dtsang7/ASAPP/models"
+	"net/http"
+)
+
+type SearchMessagesRequest struct {
+	Query  string
+	Limit  int
+	Offset int
+}
+
+// a matching message, the snippet is the HTML escaped matching part of the text with <mark> around
+// matched words
+type SearchResult struct {
+	Message Message `json:"message"`
+	Snippet string  `json:"snippet"`
+}
+
+type SearchMessagesResponse struct {
+	Results []SearchResult `json:"results"`
+}
+
+// searches the text messages the authenticated user can see, newest first
+func (h Handler) SearchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := ParseAndValidateSearchMessagesRequest(r, h.MaxPageSize)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+
+	userID := UserID(r)
+	dbResults, err := h.DB.SearchMessages(userID, req.Query, req.Limit, req.Offset)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	var dbMsgs []models.Message
+	for _, dbResult := range dbResults {
+		dbMsgs = append(dbMsgs, dbResult.Message)
+	}
+	messages, err := h.toMessages(userID, dbMsgs)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	results := []SearchResult{}
+	for i, msg := range messages {
+		results = append(results, SearchResult{msg, dbResults[i].Snippet})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(SearchMessagesResponse{results})
+	if err != nil {
+		http.Error(w, "Write error", http.StatusInternalServerError)
+	}
+}
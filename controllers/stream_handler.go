@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	sseHeartbeatPeriod = 15 * time.Second
+	sseReplayPageSize  = 100
+)
+
+// Streams messages sent to the authenticated user as server sent events. The event id
+// is the msg_id, a client reconnecting with Last-Event-ID first gets the messages it missed,
+// sent to it or by other members to its groups. Edits of messages are sent as edit events
+// without an id, they are not replayed
+func (h Handler) StreamMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	userID := UserID(r)
+	lastID := 0
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		val, err := parsePositiveInt(header)
+		if err != nil {
+			WriteHttpError(err, w)
+			return
+		}
+		lastID = val
+	}
+
+	// subscribe before replaying so messages stored in between are not missed
+	sub := h.Broker.Subscribe(userID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for lastID > 0 {
+		dbMsgs, err := h.DB.GetReceivedMessages(userID, lastID+1, sseReplayPageSize)
+		if err != nil {
+			return
+		}
+		replayed, err := h.toMessages(userID, dbMsgs)
+		if err != nil {
+			return
+		}
+		for _, msg := range replayed {
+			if err := writeMessageEvent(w, msg); err != nil {
+				return
+			}
+			lastID = msg.MsgID
+		}
+		h.markDelivered(userID, replayed)
+		if len(dbMsgs) < sseReplayPageSize {
+			break
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatPeriod)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if msg.EditedAt != "" {
+				err = writeEditEvent(w, msg)
+				break
+			}
+			// already sent while replaying
+			if msg.MsgID <= lastID {
+				continue
+			}
+			err = writeMessageEvent(w, msg)
+			if err == nil {
+				h.markDelivered(userID, []Message{msg})
+			}
+			lastID = msg.MsgID
+		case <-heartbeat.C:
+			_, err = io.WriteString(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if err != nil {
+			log.Println("error writing event stream", err.Error())
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// write message as an sse event, the data is the same json GetMessagesHandler returns
+func writeMessageEvent(w io.Writer, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", msg.MsgID, data)
+	return err
+}
+
+// write an edited message as an sse event, without an id so Last-Event-ID stays the newest message
+func writeEditEvent(w io.Writer, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: edit\ndata: %s\n\n", data)
+	return err
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/dtsang7/ASAPP/content"
+	"github.com/dtsang7/ASAPP/linkpreview"
+	"github.com/dtsang7/ASAPP/models"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	thumbnailWorkers = 2
+	thumbnailQueue   = 256
+	// largest image decoded to make thumbnails, in pixels. Decoded images take up to 4 bytes
+	// a pixel, 80MB for each worker
+	maxThumbnailSource = 20 * 1000 * 1000
+	fetchTimeout       = 10 * time.Second
+)
+
+// longest side of the thumbnails made for each image, smallest first
+var thumbnailSizes = []int{256, 1024}
+
+var errorThumbnailSource = errors.New("error image too large for thumbnails")
+
+// Thumbnailer makes the thumbnails of image messages in the background. Uploaded images
+// are read from disk, linked images are downloaded from public addresses only
+type Thumbnailer struct {
+	DB        models.Store
+	UploadDir string
+	Client    *http.Client
+	jobs      chan models.Message
+}
+
+func NewThumbnailer(store models.Store, uploadDir string) *Thumbnailer {
+	t := &Thumbnailer{
+		DB:        store,
+		UploadDir: uploadDir,
+		Client:    linkpreview.NewPublicClient(fetchTimeout),
+		jobs:      make(chan models.Message, thumbnailQueue),
+	}
+	for i := 0; i < thumbnailWorkers; i++ {
+		go t.work()
+	}
+	return t
+}
+
+// queue an image message, when the queue is full the message keeps its full size image only
+func (t *Thumbnailer) Enqueue(msg models.Message) {
+	select {
+	case t.jobs <- msg:
+	default:
+		log.Println("dropping thumbnails of message", msg.MsgID)
+	}
+}
+
+// path of the thumbnail of a message
+func (t *Thumbnailer) Path(msgID int, size int) string {
+	return filepath.Join(t.UploadDir, "thumbnails", fmt.Sprintf("%d_%d.jpg", msgID, size))
+}
+
+// remove the thumbnails of a deleted message
+func (t *Thumbnailer) Remove(msgID int) {
+	for _, size := range thumbnailSizes {
+		os.Remove(t.Path(msgID, size))
+	}
+}
+
+func (t *Thumbnailer) work() {
+	for msg := range t.jobs {
+		err := t.makeThumbnails(msg)
+		if err != nil {
+			log.Println("error making thumbnails of message", msg.MsgID, err.Error())
+		}
+	}
+}
+
+// store a thumbnail for every size smaller than the image
+func (t *Thumbnailer) makeThumbnails(msg models.Message) error {
+	data, err := t.readImage(msg)
+	if err != nil {
+		return err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if config.Width*config.Height > maxThumbnailSource {
+		return errorThumbnailSource
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Join(t.UploadDir, "thumbnails"), 0755)
+	if err != nil {
+		return err
+	}
+	bounds := src.Bounds()
+	for _, size := range thumbnailSizes {
+		width, height := scaleToFit(bounds.Dx(), bounds.Dy(), size)
+		if width == bounds.Dx() && height == bounds.Dy() {
+			break
+		}
+		// jpeg has no transparency, transparent parts become white
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+		var out bytes.Buffer
+		err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: 80})
+		if err == nil {
+			err = ioutil.WriteFile(t.Path(msg.MsgID, size), out.Bytes(), 0644)
+		}
+		if err == nil {
+			err = t.DB.AddThumbnail(models.Thumbnail{MsgID: msg.MsgID, Size: size, Width: width, Height: height})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read the image of a message from its upload or its url
+func (t *Thumbnailer) readImage(msg models.Message) ([]byte, error) {
+	img, _ := msg.Content.Payload.(content.Image)
+	if img.UploadID != "" {
+		return ioutil.ReadFile(filepath.Join(t.UploadDir, img.UploadID))
+	}
+	resp, err := t.Client.Get(img.Url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching image returned %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxUploadSize {
+		return nil, errorUploadTooLarge
+	}
+	return data, nil
+}
+
+// dimensions of width x height scaled down to fit in a size x size square, keeping the ratio
+func scaleToFit(width int, height int, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+	if width >= height {
+		return size, atLeastOne((height*size + width/2) / width)
+	}
+	return atLeastOne((width*size + height/2) / height), size
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
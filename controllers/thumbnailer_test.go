@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"github.com/dtsang7/ASAPP/content"
+	"github.com/dtsang7/ASAPP/models"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestThumbnailSourceTooLarge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thumbnails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// blank pixels compress well, the upload is small but too large to decode
+	file, err := os.Create(filepath.Join(dir, "large"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(file, image.NewGray(image.Rect(0, 0, 5000, 4001)))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thumbnailer := &Thumbnailer{UploadDir: dir}
+	msg := models.Message{MsgID: 1, Content: content.Content{Type: "image", Payload: content.Image{UploadID: "large"}}}
+	err = thumbnailer.makeThumbnails(msg)
+	if err != errorThumbnailSource {
+		t.Fatalf("expected %v, got %v", errorThumbnailSource, err)
+	}
+	if _, err := os.Stat(thumbnailer.Path(1, thumbnailSizes[0])); !os.IsNotExist(err) {
+		t.Fatal("thumbnail made of an image too large")
+	}
+}
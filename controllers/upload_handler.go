@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dtsang7/ASAPP/content"
+	"github.com/dtsang7/ASAPP/models"
+	"github.com/gorilla/mux"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	ErrorUploadTooLarge     = "error upload exceeds the size limit"
+	ErrorUploadNotSupported = "error type of upload not supported"
+	ErrorUploadNotFound     = "error upload not found"
+	ErrorInvalidUploadID    = "error upload_id must be an upload of the sender"
+)
+
+var errorUploadTooLarge = errors.New(ErrorUploadTooLarge)
+var errorUploadNotSupported = errors.New(ErrorUploadNotSupported)
+var errorUploadNotFound = errors.New(ErrorUploadNotFound)
+var errorInvalidUploadID = errors.New(ErrorInvalidUploadID)
+
+// largest image accepted by POST /uploads, other files are limited by FileLimits
+const maxUploadSize = 10 << 20
+
+// longest filename kept from an upload, in bytes
+const maxFilenameSize = 255
+
+// mime types of the images that can be uploaded, detected from the file content
+var uploadTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// FileLimits restricts the files uploaded for file messages, set from the config
+type FileLimits struct {
+	MaxSize int64
+	// mime types detected from the file content, without parameters
+	Types map[string]bool
+}
+
+func NewFileLimits(maxSize int64, types []string) FileLimits {
+	limits := FileLimits{MaxSize: maxSize, Types: make(map[string]bool)}
+	for _, mimeType := range types {
+		limits.Types[mimeType] = true
+	}
+	return limits
+}
+
+// check a file against the limits, files too large are reported before unsupported types
+func (limits FileLimits) check(mimeType string, size int64) error {
+	if size > limits.MaxSize {
+		log.Println(errorUploadTooLarge)
+		return errorUploadTooLarge
+	}
+	if !limits.Types[mimeType] {
+		log.Println(errorUploadNotSupported)
+		return errorUploadNotSupported
+	}
+	return nil
+}
+
+type UploadResponse struct {
+	Id       string `json:"id"`
+	Url      string `json:"url"`
+	Filename string `json:"filename"`
+	MimeType string `json:"mime_type"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Size     int64  `json:"size"`
+	Sha256   string `json:"sha256"`
+}
+
+// stores the file sent as the multipart "file" field, the type is detected from its content.
+// Images have their dimensions read and can be sent in image messages, other files within the
+// FileLimits can be sent in file messages. The returned id is sent as upload_id in the content
+func (h Handler) UploadHandler(w http.ResponseWriter, r *http.Request) {
+	maxSize := int64(maxUploadSize)
+	if h.Files.MaxSize > maxSize {
+		maxSize = h.Files.MaxSize
+	}
+	// leave room for the multipart headers around the file
+	maxBodySize := maxSize + 1<<20
+	if r.ContentLength > maxBodySize {
+		WriteHttpError(errorUploadTooLarge, w)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		log.Println("error reading upload", err.Error())
+		WriteHttpError(errorMissingArgument, w)
+		return
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		log.Println("error reading upload", err.Error())
+		WriteHttpError(errorUploadTooLarge, w)
+		return
+	}
+	upload := models.Upload{
+		UserID:   UserID(r),
+		Size:     int64(len(data)),
+		Filename: cleanFilename(header.Filename),
+	}
+	upload.MimeType, _, err = mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		WriteHttpError(errorUploadNotSupported, w)
+		return
+	}
+	if uploadTypes[upload.MimeType] && upload.Size <= maxUploadSize {
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err == nil && config.Width > 0 && config.Height > 0 {
+			upload.Width, upload.Height = config.Width, config.Height
+		}
+	}
+	if upload.Width == 0 {
+		err = h.Files.check(upload.MimeType, upload.Size)
+		if err != nil {
+			WriteHttpError(err, w)
+			return
+		}
+	}
+	sum := sha256.Sum256(data)
+	upload.Sha256 = hex.EncodeToString(sum[:])
+
+	upload.UploadID, err = newRandomID()
+	if err != nil {
+		http.Error(w, "Upload error", http.StatusInternalServerError)
+		return
+	}
+	err = os.MkdirAll(h.UploadDir, 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(h.UploadDir, upload.UploadID), data, 0644)
+	}
+	if err != nil {
+		log.Println("error storing upload", err.Error())
+		http.Error(w, "Upload error", http.StatusInternalServerError)
+		return
+	}
+	err = h.DB.CreateUpload(upload)
+	if err != nil {
+		os.Remove(filepath.Join(h.UploadDir, upload.UploadID))
+		WriteHttpError(err, w)
+		return
+	}
+
+	resp := UploadResponse{upload.UploadID, uploadUrl(upload.UploadID), upload.Filename, upload.MimeType, upload.Width, upload.Height, upload.Size, upload.Sha256}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, "Write error", http.StatusInternalServerError)
+	}
+}
+
+// last element of the name the client gave the file, without characters unsafe in headers
+func cleanFilename(name string) string {
+	name = path.Base(strings.Replace(name, "\\", "/", -1))
+	name = strings.Map(func(c rune) rune {
+		if c == utf8.RuneError || unicode.IsControl(c) || c == '"' {
+			return -1
+		}
+		return c
+	}, name)
+	for len(name) > maxFilenameSize {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+// downloads an upload, only its uploader and the users who can see a message sent from it can
+func (h Handler) GetUploadHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["uploadId"]
+	visible, err := h.DB.CanViewUpload(id, UserID(r))
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	if !visible {
+		WriteHttpError(errorUploadNotFound, w)
+		return
+	}
+	upload, err := h.DB.GetUpload(id)
+	if err != nil {
+		WriteHttpError(errorUploadNotFound, w)
+		return
+	}
+	file, err := os.Open(filepath.Join(h.UploadDir, id))
+	if err != nil {
+		log.Println("error opening upload", err.Error())
+		WriteHttpError(errorUploadNotFound, w)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", upload.MimeType)
+	if upload.Width == 0 {
+		// files are downloaded, never displayed from the origin of the api
+		disposition := mime.FormatMediaType("attachment", map[string]string{"filename": upload.Filename})
+		if disposition == "" {
+			disposition = "attachment"
+		}
+		w.Header().Set("Content-Disposition", disposition)
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	http.ServeContent(w, r, "", time.Time{}, file)
+}
+
+// content of an image or file message sent from an upload of the sender, only images can be
+// sent in image messages and files must still be within the limits
+func (h Handler) uploadContent(senderID int, mtype string, uploadID string) (MessageContent, error) {
+	upload, err := h.DB.GetUpload(uploadID)
+	if err != nil && err.Error() == models.ErrorUploadDoesNotExist {
+		return MessageContent{}, errorInvalidUploadID
+	}
+	if err != nil {
+		return MessageContent{}, err
+	}
+	if upload.UserID != senderID {
+		return MessageContent{}, errorInvalidUploadID
+	}
+	if mtype == "image" {
+		if upload.Width == 0 {
+			return MessageContent{}, errorUploadNotSupported
+		}
+		return MessageContent{Type: "image", Payload: content.Image{
+			Width:    upload.Width,
+			Height:   upload.Height,
+			Url:      uploadUrl(upload.UploadID),
+			UploadID: upload.UploadID,
+		}}, nil
+	}
+	err = h.Files.check(upload.MimeType, upload.Size)
+	if err != nil {
+		return MessageContent{}, err
+	}
+	return MessageContent{Type: "file", Payload: content.File{
+		Url:      uploadUrl(upload.UploadID),
+		UploadID: upload.UploadID,
+		Filename: upload.Filename,
+		MimeType: upload.MimeType,
+		Size:     upload.Size,
+		Sha256:   upload.Sha256,
+	}}, nil
+}
+
+// id of the upload an image or file is sent from, empty for other contents
+func uploadOf(c MessageContent) string {
+	switch p := c.Payload.(type) {
+	case content.Image:
+		return p.UploadID
+	case content.File:
+		return p.UploadID
+	}
+	return ""
+}
+
+func uploadUrl(uploadID string) string {
+	return "/uploads/" + uploadID
+}
+
+func thumbnailUrl(msgID int, size int) string {
+	return fmt.Sprintf("/messages/%d/thumbnails/%d", msgID, size)
+}
+
+// downloads a thumbnail of an image message the authenticated user can see
+func (h Handler) GetThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	msgID, err := parseMsgID(r)
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	size, err := parsePositiveInt(mux.Vars(r)["size"])
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	dbMsg, err := h.findMessage(msgID, UserID(r))
+	if err != nil {
+		WriteHttpError(err, w)
+		return
+	}
+	if dbMsg.DeletedAt.Valid {
+		WriteHttpError(errorMessageDeleted, w)
+		return
+	}
+	file, err := os.Open(h.Thumbnails.Path(msgID, size))
+	if err != nil {
+		WriteHttpError(errorUploadNotFound, w)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	http.ServeContent(w, r, "", time.Time{}, file)
+}
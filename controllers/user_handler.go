@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/dtsang7/ASAPP/models"
 	"net/http"
 )
@@ -11,8 +12,13 @@ type CreateUserResponse struct {
 }
 
 type LoginResponse struct {
-	Id    int
-	Token string
+	Id           int
+	Token        string
+	RefreshToken string
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 //handles creating new user
@@ -52,16 +58,71 @@ func (h Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//authenticate user
-	id, tokenString, err := h.Authenticate(existingUser)
+	id, tokens, err := h.Authenticate(existingUser)
 	if err != nil {
 		WriteHttpError(err, w)
 		return
 	}
 
-	resp := LoginResponse{id, tokenString}
+	resp := LoginResponse{id, tokens.AccessToken, tokens.RefreshToken}
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(resp)
 	if err != nil {
 		http.Error(w, "Write error", http.StatusInternalServerError)
 	}
 }
+
+// handles exchanging a refresh token for a new token pair
+func (h Handler) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	if req.RefreshToken == "" {
+		WriteHttpError(errorMissingArgument, w)
+		return
+	}
+
+	id, tokens, err := h.Refresh(req.RefreshToken)
+	if err != nil {
+		writeTokenError(err, w)
+		return
+	}
+
+	resp := LoginResponse{id, tokens.AccessToken, tokens.RefreshToken}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, "Write error", http.StatusInternalServerError)
+	}
+}
+
+// handles logout, revokes the access token and optionally the refresh token
+func (h Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	claims := r.Context().Value("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	if req.RefreshToken != "" {
+		refreshClaims, err := h.parseToken(req.RefreshToken, refreshTokenType)
+		if err != nil {
+			writeTokenError(err, w)
+			return
+		}
+		if refreshClaims["id"] != claims["id"] {
+			WriteHttpStatusError(errorInvalidToken, http.StatusUnauthorized, w)
+			return
+		}
+		err = h.Revoke(refreshClaims)
+		if err != nil {
+			http.Error(w, "Revoke error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err := h.Revoke(claims)
+	if err != nil {
+		http.Error(w, "Revoke error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
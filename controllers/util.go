@@ -4,7 +4,30 @@ import (
 	"net/http"
 )
 
+// errors written with a status other than 400 Bad Request
+var errorStatus = map[error]int{
+	errorMismatchIDMessage:  http.StatusForbidden,
+	errorNotGroupMember:     http.StatusForbidden,
+	errorNotGroupOwner:      http.StatusForbidden,
+	errorOwnerCannotLeave:   http.StatusForbidden,
+	errorMessageNotFound:    http.StatusNotFound,
+	errorNotMessageSender:   http.StatusForbidden,
+	errorMessageDeleted:     http.StatusGone,
+	errorUploadNotFound:     http.StatusNotFound,
+	errorUploadTooLarge:     http.StatusRequestEntityTooLarge,
+	errorUploadNotSupported: http.StatusUnsupportedMediaType,
+}
+
 // Write common http error
 func WriteHttpError(err error, w http.ResponseWriter) {
-	http.Error(w, err.Error(), http.StatusBadRequest)
+	status, found := errorStatus[err]
+	if !found {
+		status = http.StatusBadRequest
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// Write http error with a specific status code
+func WriteHttpStatusError(err error, status int, w http.ResponseWriter) {
+	http.Error(w, err.Error(), status)
 }
@@ -2,25 +2,46 @@ package controllers
 
 import (
 	"errors"
+	"github.com/dtsang7/ASAPP/content"
 	"github.com/dtsang7/ASAPP/models"
+	"github.com/gorilla/mux"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
 	ErrorMissingArgument    = "error missing argument"
 	ErrorUsernameExceedSize = "error username exceed size limit"
 	ErrorPasswordExceedSize = "error password exceed size limit"
-	ErrorSourceNotSupported = "error video source not supported"
-	ErrorTypeNotSupported   = "error type of message not supported"
+	ErrorInvalidWait        = "error wait must be a positive duration"
+	ErrorRecipientOrGroup   = "error message requires either a recipient or a group"
+	ErrorGroupNameSize      = "error group name must be 1 to 100 characters"
+	ErrorInvalidEmoji       = "error reaction must be a single emoji"
+	ErrorInvalidReplyTo     = "error reply_to must be a message of the same conversation"
+	ErrorSearchQuerySize    = "error search query must be 1 to 200 characters"
 )
 
 var errorMissingArgument = errors.New(ErrorMissingArgument)
 var errorUsernameExceedSize = errors.New(ErrorUsernameExceedSize)
 var errorPasswordExceedSize = errors.New(ErrorPasswordExceedSize)
-var errorSourceNotSupported = errors.New(ErrorSourceNotSupported)
-var errorTypeNotSupported = errors.New(ErrorTypeNotSupported)
+var errorInvalidWait = errors.New(ErrorInvalidWait)
+var errorRecipientOrGroup = errors.New(ErrorRecipientOrGroup)
+var errorGroupNameSize = errors.New(ErrorGroupNameSize)
+var errorInvalidEmoji = errors.New(ErrorInvalidEmoji)
+var errorInvalidReplyTo = errors.New(ErrorInvalidReplyTo)
+var errorSearchQuerySize = errors.New(ErrorSearchQuerySize)
+
+// longest emoji stored as a reaction, in bytes
+const maxEmojiSize = 32
+
+// longest a GET /messages request is held open waiting for new messages
+const maxWait = time.Second * 60
 
 func ValidateUser(usr models.User) error {
 	if usr.Username == "" || usr.Password == "" {
@@ -40,35 +61,109 @@ func ValidateUser(usr models.User) error {
 	return nil
 }
 
-func ValidateSendMessage(req Message) error {
-	if req.SenderID <= 0 || req.RecipientID <= 0 || req.Content.Type == "" {
+// a message is sent either to a recipient or to a group. A reply quotes a message found
+// by findMessage, visible to the sender and sent in the same conversation
+func ValidateSendMessage(req *Message, findMessage func(msgID int, uid int) (models.Message, error)) error {
+	if req.SenderID <= 0 || req.Content.Type == "" || req.ReplyTo < 0 {
 		log.Println(errorMissingArgument)
 		return errorMissingArgument
 	}
+	if (req.RecipientID <= 0) == (req.GroupID <= 0) {
+		log.Println(errorRecipientOrGroup)
+		return errorRecipientOrGroup
+	}
 
-	switch req.Content.Type {
-	case "text":
-		if req.Content.Text == "" {
-			log.Println(errorMissingArgument)
-			return errorMissingArgument
+	// the content is checked by its type and replaced by the form it is stored in
+	c, err := content.Validate(req.Content)
+	if err != nil {
+		return err
+	}
+	req.Content = c
+
+	if req.ReplyTo > 0 {
+		quoted, err := findMessage(req.ReplyTo, req.SenderID)
+		if err != nil && err != errorMessageNotFound {
+			return err
 		}
-	case "image":
-		if req.Content.Width <= 0 || req.Content.Height <= 0 || req.Content.Url == "" {
-			log.Println(errorMissingArgument)
-			return errorMissingArgument
+		if err != nil || quoted.DeletedAt.Valid || !sameConversation(*req, quoted) {
+			log.Println(errorInvalidReplyTo)
+			return errorInvalidReplyTo
 		}
-	case "video":
-		if req.Content.Source == "" || req.Content.Url == "" {
+	}
+	return nil
+}
+
+// whether a stored message belongs to the conversation req is sent to
+func sameConversation(req Message, dbMsg models.Message) bool {
+	if req.GroupID > 0 {
+		return dbMsg.GroupID == req.GroupID
+	}
+	if dbMsg.GroupID > 0 {
+		return false
+	}
+	return (dbMsg.SenderID == req.SenderID && dbMsg.RecipientID == req.RecipientID) ||
+		(dbMsg.SenderID == req.RecipientID && dbMsg.RecipientID == req.SenderID)
+}
+
+func ValidateCreateGroup(req CreateGroupRequest) error {
+	if req.Name == "" || len(req.Name) > 100 {
+		log.Println(errorGroupNameSize)
+		return errorGroupNameSize
+	}
+	for _, id := range req.Members {
+		if id <= 0 {
 			log.Println(errorMissingArgument)
 			return errorMissingArgument
 		}
-		if req.Content.Source != "youtube" && req.Content.Source != "vimeo" {
-			log.Println(errorSourceNotSupported)
-			return errorSourceNotSupported
+	}
+	return nil
+}
+
+// the new content must be of the type of the message, a type that can be updated
+func ValidateEditMessage(req EditMessageRequest, mtype string) (MessageContent, error) {
+	t, found := content.Lookup(mtype)
+	if _, ok := t.(content.Updater); !found || !ok || req.Content.Type != mtype {
+		log.Println(errorEditNotSupported)
+		return req.Content, errorEditNotSupported
+	}
+	p, err := t.Validate(req.Content.Payload)
+	if err != nil {
+		return req.Content, err
+	}
+	return MessageContent{Type: mtype, Payload: p}, nil
+}
+
+// an emoji can span several code points (modifiers, joiners) but is never plain text
+func ValidateReaction(emoji string) error {
+	if emoji == "" {
+		log.Println(errorMissingArgument)
+		return errorMissingArgument
+	}
+	if len(emoji) > maxEmojiSize || !utf8.ValidString(emoji) {
+		log.Println(errorInvalidEmoji)
+		return errorInvalidEmoji
+	}
+	emojiRune := false
+	for _, c := range emoji {
+		if unicode.IsSpace(c) || unicode.IsControl(c) {
+			log.Println(errorInvalidEmoji)
+			return errorInvalidEmoji
+		}
+		if c > unicode.MaxASCII {
+			emojiRune = true
 		}
-	default:
-		log.Println(errorTypeNotSupported)
-		return errorTypeNotSupported
+	}
+	if !emojiRune {
+		log.Println(errorInvalidEmoji)
+		return errorInvalidEmoji
+	}
+	return nil
+}
+
+func ValidateMarkRead(req MarkReadRequest) error {
+	if req.MsgID <= 0 || req.SenderID < 0 {
+		log.Println(errorMissingArgument)
+		return errorMissingArgument
 	}
 	return nil
 }
@@ -85,8 +180,20 @@ func parsePositiveInt(str string) (int, error) {
 	return int(intVal), nil
 }
 
+// Parse int from string, expect zero or greater
+func parseNonNegativeInt(str string) (int, error) {
+	intVal, parseErr := strconv.ParseInt(str, 10, 64)
+	if parseErr != nil {
+		return int(intVal), parseErr
+	}
+	if intVal < 0 {
+		return 0, errorMissingArgument
+	}
+	return int(intVal), nil
+}
+
 // Parse query parameters and validate them
-func ParseAndValidateGetMessageRequest(r *http.Request) (req GetMessagesRequest, err error) {
+func ParseAndValidateGetMessageRequest(r *http.Request, maxLimit int) (req GetMessagesRequest, err error) {
 	params := r.URL.Query()
 	// parse recipient, required
 	if val, parseErr := parsePositiveInt(params.Get("recipient")); parseErr == nil {
@@ -95,6 +202,156 @@ func ParseAndValidateGetMessageRequest(r *http.Request) (req GetMessagesRequest,
 		err = parseErr
 		return
 	}
+	// parse cursor, optional, it replaces start and direction
+	if params.Get("cursor") != "" {
+		c, parseErr := parseCursor(params.Get("cursor"), req.RecipientID)
+		if parseErr != nil {
+			err = parseErr
+			return
+		}
+		req.Direction, req.StartMsgID = c.Direction, c.StartMsgID
+	} else {
+		// parse start, optional, without it pages are read from the newest message
+		if params.Get("start") != "" {
+			if req.StartMsgID, err = parsePositiveInt(params.Get("start")); err != nil {
+				return
+			}
+		}
+		// parse direction, optional, newer from start when it is set and older otherwise
+		switch params.Get("direction") {
+		case directionOlder, directionNewer:
+			req.Direction = params.Get("direction")
+		case "":
+			req.Direction = directionOlder
+			if req.StartMsgID > 0 {
+				req.Direction = directionNewer
+			}
+		default:
+			err = errorInvalidDirection
+			return
+		}
+		if req.Direction == directionNewer && req.StartMsgID == 0 {
+			req.StartMsgID = 1
+		}
+	}
+	// parse limit, optional, capped at maxLimit
+	req.Limit = parseLimit(params, maxLimit)
+	// parse wait, optional, capped at maxWait
+	if params.Get("wait") != "" {
+		val, parseErr := time.ParseDuration(params.Get("wait"))
+		if parseErr != nil || val <= 0 {
+			err = errorInvalidWait
+			return
+		}
+		if val > maxWait {
+			val = maxWait
+		}
+		req.Wait = val
+	}
+	return
+}
+
+// Parse conversation list paging parameters, both optional
+func ParseAndValidateGetConversationsRequest(r *http.Request, maxLimit int) (req GetConversationsRequest, err error) {
+	params := r.URL.Query()
+	// parse limit, optional, capped at maxLimit
+	req.Limit = parseLimit(params, maxLimit)
+	// parse offset, optional
+	if params.Get("offset") != "" {
+		val, parseErr := parseNonNegativeInt(params.Get("offset"))
+		if parseErr != nil {
+			err = parseErr
+			return
+		}
+		req.Offset = val
+	}
+	return
+}
+
+// Parse the search query, required, and the paging parameters
+func ParseAndValidateSearchMessagesRequest(r *http.Request, maxLimit int) (req SearchMessagesRequest, err error) {
+	params := r.URL.Query()
+	// parse q, required
+	req.Query = strings.TrimSpace(params.Get("q"))
+	if req.Query == "" || len(req.Query) > 200 {
+		log.Println(errorSearchQuerySize)
+		err = errorSearchQuerySize
+		return
+	}
+	// parse limit, optional, capped at maxLimit
+	req.Limit = parseLimit(params, maxLimit)
+	// parse offset, optional
+	if params.Get("offset") != "" {
+		val, parseErr := parseNonNegativeInt(params.Get("offset"))
+		if parseErr != nil {
+			err = parseErr
+			return
+		}
+		req.Offset = val
+	}
+	return
+}
+
+// Parse the conversation user from the path and the cursor parameters
+func ParseAndValidateGetConversationMessagesRequest(r *http.Request, maxLimit int) (req GetConversationMessagesRequest, err error) {
+	params := r.URL.Query()
+	// parse user, required
+	if val, parseErr := parsePositiveInt(mux.Vars(r)["userId"]); parseErr == nil {
+		req.UserID = val
+	} else {
+		err = parseErr
+		return
+	}
+	// parse before, optional
+	if params.Get("before") != "" {
+		if req.Before, err = parsePositiveInt(params.Get("before")); err != nil {
+			return
+		}
+	}
+	// parse after, optional
+	if params.Get("after") != "" {
+		if req.After, err = parsePositiveInt(params.Get("after")); err != nil {
+			return
+		}
+	}
+	// parse limit, optional, capped at maxLimit
+	req.Limit = parseLimit(params, maxLimit)
+	return
+}
+
+// Parse the limit parameter of a page, 100 when missing or invalid and at most maxLimit
+// when it is set
+func parseLimit(params url.Values, maxLimit int) int {
+	limit, err := parsePositiveInt(params.Get("limit"))
+	if err != nil {
+		limit = 100
+	}
+	if maxLimit > 0 && limit > maxLimit {
+		limit = maxLimit
+	}
+	return limit
+}
+
+// Parse message id from the path
+func parseMsgID(r *http.Request) (int, error) {
+	return parsePositiveInt(mux.Vars(r)["msgId"])
+}
+
+// Parse group id from the path
+func parseGroupID(r *http.Request) (int, error) {
+	return parsePositiveInt(mux.Vars(r)["groupId"])
+}
+
+// Parse group history parameters, same as GET /messages with the group instead of the recipient
+func ParseAndValidateGetGroupMessagesRequest(r *http.Request, maxLimit int) (req GetGroupMessagesRequest, err error) {
+	params := r.URL.Query()
+	// parse group, required
+	if val, parseErr := parseGroupID(r); parseErr == nil {
+		req.GroupID = val
+	} else {
+		err = parseErr
+		return
+	}
 	// parse start, required
 	if val, parseErr := parsePositiveInt(params.Get("start")); parseErr == nil {
 		req.StartMsgID = val
@@ -102,11 +359,7 @@ func ParseAndValidateGetMessageRequest(r *http.Request) (req GetMessagesRequest,
 		err = parseErr
 		return
 	}
-	// parse limit, optional
-	if val, parseErr := parsePositiveInt(params.Get("limit")); parseErr == nil {
-		req.Limit = val
-	} else {
-		req.Limit = 100
-	}
+	// parse limit, optional, capped at maxLimit
+	req.Limit = parseLimit(params, maxLimit)
 	return
 }
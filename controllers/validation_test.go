@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPageLimits(t *testing.T) {
+	const maxLimit = 50
+	request := func(url string) *http.Request {
+		r := httptest.NewRequest("GET", url, nil)
+		return mux.SetURLVars(r, map[string]string{"userId": "2", "groupId": "3"})
+	}
+	parsers := map[string]func(url string) (int, error){
+		"messages": func(url string) (int, error) {
+			req, err := ParseAndValidateGetMessageRequest(request("/messages?recipient=2&"+url), maxLimit)
+			return req.Limit, err
+		},
+		"conversations": func(url string) (int, error) {
+			req, err := ParseAndValidateGetConversationsRequest(request("/conversations?"+url), maxLimit)
+			return req.Limit, err
+		},
+		"conversation messages": func(url string) (int, error) {
+			req, err := ParseAndValidateGetConversationMessagesRequest(request("/conversations/2/messages?"+url), maxLimit)
+			return req.Limit, err
+		},
+		"group messages": func(url string) (int, error) {
+			req, err := ParseAndValidateGetGroupMessagesRequest(request("/groups/3/messages?start=1&"+url), maxLimit)
+			return req.Limit, err
+		},
+		"search": func(url string) (int, error) {
+			req, err := ParseAndValidateSearchMessagesRequest(request("/messages/search?q=hi&"+url), maxLimit)
+			return req.Limit, err
+		},
+	}
+	limits := []struct {
+		query string
+		limit int
+	}{
+		{"limit=10", 10},
+		{"limit=1000", maxLimit},
+		{"", maxLimit},
+	}
+	for name, parse := range parsers {
+		for _, l := range limits {
+			limit, err := parse(l.query)
+			if err != nil || limit != l.limit {
+				t.Errorf("%s %q: expected limit %d, got %d %v", name, l.query, l.limit, limit, err)
+			}
+		}
+	}
+}
+
+func TestCursorRecipient(t *testing.T) {
+	c := cursor{RecipientID: 2, Direction: directionOlder, StartMsgID: 7}
+	parsed, err := parseCursor(c.String(), 2)
+	if err != nil || parsed != c {
+		t.Fatalf("expected %v, got %v %v", c, parsed, err)
+	}
+	if _, err := parseCursor(c.String(), 3); err != errorInvalidCursor {
+		t.Fatalf("cursor of another recipient accepted %v", err)
+	}
+}
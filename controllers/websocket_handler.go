@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = (wsPongWait * 9) / 10
+	wsMaxMessageSize = 64 * 1024
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// error frame written back when a message sent over the socket is rejected
+type WebSocketError struct {
+	Error string `json:"error"`
+}
+
+// Upgrades to a websocket. Messages addressed to the user are pushed as Message
+// frames, Message frames sent by the client are stored and echoed back once stored
+func (h Handler) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r)
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("error upgrading to websocket", err.Error())
+		return
+	}
+
+	sub := h.Broker.Subscribe(userID)
+	replies := make(chan interface{}, subscriptionBuffer)
+	readerDone := make(chan struct{})
+	writerDone := make(chan struct{})
+	go h.readWebSocket(conn, userID, replies, readerDone, writerDone)
+	h.writeWebSocket(conn, sub, replies, readerDone)
+	close(writerDone)
+}
+
+// read frames from the client until the connection fails, the writer owns the connection
+func (h Handler) readWebSocket(conn *websocket.Conn, userID int, replies chan<- interface{}, readerDone chan<- struct{}, writerDone <-chan struct{}) {
+	defer close(readerDone)
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("error reading websocket", err.Error())
+			}
+			return
+		}
+
+		var reply interface{}
+		var req Message
+		err = json.Unmarshal(frame, &req)
+		if err == nil {
+			reply, err = h.sendMessage(userID, req)
+		}
+		if err != nil {
+			reply = WebSocketError{err.Error()}
+		}
+
+		select {
+		case replies <- reply:
+		case <-writerDone:
+			return
+		}
+	}
+}
+
+// write pushed messages, replies and pings until the reader stops or a write fails
+func (h Handler) writeWebSocket(conn *websocket.Conn, sub *Subscription, replies <-chan interface{}, readerDone <-chan struct{}) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		sub.Close()
+		conn.Close()
+	}()
+
+	for {
+		var err error
+		select {
+		case msg := <-sub.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteJSON(msg)
+			if err == nil {
+				h.markDelivered(sub.UserID, []Message{msg})
+			}
+		case reply := <-replies:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteJSON(reply)
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteMessage(websocket.PingMessage, nil)
+		case <-readerDone:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+		if err != nil {
+			log.Println("error writing websocket", err.Error())
+			return
+		}
+	}
+}
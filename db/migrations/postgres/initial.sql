@@ -0,0 +1,56 @@
+-- +migrate Up
+-- every migration has the id of the same change in db/migrations/sqlite3. Databases created
+-- when this migration held the whole schema get the later ones applied too, they only add
+-- what is missing
+CREATE TABLE IF NOT EXISTS messageType (
+	mtype TEXT PRIMARY KEY NOT NULL
+);
+
+INSERT INTO messageType (mtype) VALUES ('text'), ('image'), ('video') ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS sourceType (
+	stype TEXT PRIMARY KEY NOT NULL
+);
+
+INSERT INTO sourceType (stype) VALUES ('youtube'), ('vimeo') ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS users (
+	uid SERIAL PRIMARY KEY,
+	username VARCHAR(50) NOT NULL,
+	password VARCHAR(100) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+	msg_id SERIAL PRIMARY KEY,
+	sender_id INTEGER REFERENCES users(uid),
+	recipient_id INTEGER REFERENCES users(uid),
+	type TEXT REFERENCES messageType(mtype),
+	created_on TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS texts (
+	msg_id INTEGER REFERENCES messages(msg_id),
+	msg TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS images (
+	msg_id INTEGER REFERENCES messages(msg_id),
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	i_url TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS videos (
+	msg_id INTEGER REFERENCES messages(msg_id),
+	source TEXT REFERENCES sourceType(stype),
+	v_url TEXT NOT NULL
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS videos;
+DROP TABLE IF EXISTS images;
+DROP TABLE IF EXISTS texts;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS sourceType;
+DROP TABLE IF EXISTS messageType;
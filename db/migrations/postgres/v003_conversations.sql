@@ -0,0 +1,11 @@
+-- +migrate Up
+-- read_at is set once the recipient has read the message, unread messages have NULL
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS read_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS messages_recipient ON messages (recipient_id, sender_id);
+CREATE INDEX IF NOT EXISTS messages_sender ON messages (sender_id, recipient_id);
+
+-- +migrate Down
+DROP INDEX IF EXISTS messages_sender;
+DROP INDEX IF EXISTS messages_recipient;
+ALTER TABLE messages DROP COLUMN IF EXISTS read_at;
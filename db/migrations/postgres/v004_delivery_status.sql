@@ -0,0 +1,6 @@
+-- +migrate Up
+-- delivered_at is set once the message has been fetched or pushed to the recipient
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMPTZ;
+
+-- +migrate Down
+ALTER TABLE messages DROP COLUMN IF EXISTS delivered_at;
@@ -0,0 +1,25 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS groups (
+	group_id SERIAL PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	owner_id INTEGER NOT NULL REFERENCES users(uid),
+	created_on TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+	group_id INTEGER NOT NULL REFERENCES groups(group_id),
+	uid INTEGER NOT NULL REFERENCES users(uid),
+	joined_on TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (group_id, uid)
+);
+
+-- group messages are stored once with group_id set and no recipient_id
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS group_id INTEGER REFERENCES groups(group_id);
+
+CREATE INDEX IF NOT EXISTS messages_group ON messages (group_id);
+
+-- +migrate Down
+DROP INDEX IF EXISTS messages_group;
+ALTER TABLE messages DROP COLUMN IF EXISTS group_id;
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS groups;
@@ -0,0 +1,11 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS reactions (
+	msg_id INTEGER NOT NULL REFERENCES messages(msg_id),
+	uid INTEGER NOT NULL REFERENCES users(uid),
+	emoji VARCHAR(32) NOT NULL,
+	created_on TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (msg_id, uid, emoji)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS reactions;
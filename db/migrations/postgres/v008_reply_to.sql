@@ -0,0 +1,6 @@
+-- +migrate Up
+-- a reply references the message it quotes, in the same conversation
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to INTEGER REFERENCES messages(msg_id);
+
+-- +migrate Down
+ALTER TABLE messages DROP COLUMN IF EXISTS reply_to;
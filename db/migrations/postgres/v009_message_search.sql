@@ -0,0 +1,6 @@
+-- +migrate Up
+-- full-text index of texts, words are not stemmed, like in the SQLite FTS5 index
+CREATE INDEX IF NOT EXISTS texts_search ON texts USING GIN (to_tsvector('simple', msg));
+
+-- +migrate Down
+DROP INDEX IF EXISTS texts_search;
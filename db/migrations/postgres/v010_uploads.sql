@@ -0,0 +1,21 @@
+-- +migrate Up
+-- files uploaded by users, stored on disk under the configured upload_dir by upload_id
+CREATE TABLE IF NOT EXISTS uploads (
+	upload_id VARCHAR(32) PRIMARY KEY NOT NULL,
+	uid INTEGER NOT NULL REFERENCES users(uid),
+	mime_type TEXT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	size BIGINT NOT NULL,
+	created_on TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- image messages sent from an upload
+ALTER TABLE images ADD COLUMN IF NOT EXISTS upload_id VARCHAR(32) REFERENCES uploads(upload_id);
+
+CREATE INDEX IF NOT EXISTS images_upload ON images (upload_id);
+
+-- +migrate Down
+DROP INDEX IF EXISTS images_upload;
+ALTER TABLE images DROP COLUMN IF EXISTS upload_id;
+DROP TABLE IF EXISTS uploads;
@@ -0,0 +1,13 @@
+-- +migrate Up
+-- downscaled copies of the image of a message, stored on disk by msg_id and size
+CREATE TABLE IF NOT EXISTS thumbnails (
+	msg_id INTEGER NOT NULL REFERENCES messages(msg_id),
+	size INTEGER NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	created_on TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (msg_id, size)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS thumbnails;
@@ -0,0 +1,26 @@
+-- +migrate Up
+INSERT INTO messageType (mtype) VALUES ('file') ON CONFLICT DO NOTHING;
+
+-- name the file was uploaded with and checksum of its content
+ALTER TABLE uploads ADD COLUMN IF NOT EXISTS filename TEXT NOT NULL DEFAULT '';
+ALTER TABLE uploads ADD COLUMN IF NOT EXISTS sha256 VARCHAR(64) NOT NULL DEFAULT '';
+
+-- file messages, always sent from an upload
+CREATE TABLE IF NOT EXISTS files (
+	msg_id INTEGER PRIMARY KEY NOT NULL REFERENCES messages(msg_id),
+	upload_id VARCHAR(32) NOT NULL REFERENCES uploads(upload_id),
+	f_url TEXT NOT NULL,
+	filename TEXT NOT NULL,
+	mime_type TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	sha256 VARCHAR(64) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS files_upload ON files (upload_id);
+
+-- +migrate Down
+DROP INDEX IF EXISTS files_upload;
+DROP TABLE IF EXISTS files;
+ALTER TABLE uploads DROP COLUMN IF EXISTS sha256;
+ALTER TABLE uploads DROP COLUMN IF EXISTS filename;
+DELETE FROM messageType WHERE mtype = 'file';
@@ -0,0 +1,15 @@
+-- +migrate Up
+INSERT INTO messageType (mtype) VALUES ('audio') ON CONFLICT DO NOTHING;
+
+-- audio messages, the waveform is one byte per sample
+CREATE TABLE IF NOT EXISTS audios (
+	msg_id INTEGER PRIMARY KEY NOT NULL REFERENCES messages(msg_id),
+	a_url TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL,
+	codec TEXT NOT NULL,
+	waveform BYTEA
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS audios;
+DELETE FROM messageType WHERE mtype = 'audio';
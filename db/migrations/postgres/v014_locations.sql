@@ -0,0 +1,16 @@
+-- +migrate Up
+INSERT INTO messageType (mtype) VALUES ('location') ON CONFLICT DO NOTHING;
+
+-- location messages, live locations have the time until which they can be moved
+CREATE TABLE IF NOT EXISTS locations (
+	msg_id INTEGER PRIMARY KEY NOT NULL REFERENCES messages(msg_id),
+	latitude DOUBLE PRECISION NOT NULL,
+	longitude DOUBLE PRECISION NOT NULL,
+	accuracy DOUBLE PRECISION NOT NULL DEFAULT 0,
+	label TEXT NOT NULL DEFAULT '',
+	live_until TIMESTAMPTZ
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS locations;
+DELETE FROM messageType WHERE mtype = 'location';
@@ -0,0 +1,13 @@
+-- +migrate Up
+-- OpenGraph preview of the first link of a text message
+CREATE TABLE IF NOT EXISTS link_previews (
+	msg_id INTEGER PRIMARY KEY NOT NULL REFERENCES messages(msg_id),
+	url TEXT NOT NULL,
+	title TEXT NOT NULL,
+	description TEXT NOT NULL,
+	image_url TEXT NOT NULL,
+	created_on TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS link_previews;
@@ -0,0 +1,9 @@
+-- +migrate Up
+-- Migration ids are sorted as strings after initial.sql, keep the vNNN_ prefix
+CREATE TABLE IF NOT EXISTS 'revoked_tokens' (
+	jti TEXT PRIMARY KEY NOT NULL,
+	expires_on DATETIME NOT NULL
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS 'revoked_tokens';
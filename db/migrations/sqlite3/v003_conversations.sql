@@ -0,0 +1,11 @@
+-- +migrate Up
+-- read_at is set once the recipient has read the message, unread messages have NULL
+ALTER TABLE 'messages' ADD COLUMN read_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS messages_recipient ON messages (recipient_id, sender_id);
+CREATE INDEX IF NOT EXISTS messages_sender ON messages (sender_id, recipient_id);
+
+-- +migrate Down
+-- SQLite can't drop the read_at column, it is left in place
+DROP INDEX IF EXISTS messages_sender;
+DROP INDEX IF EXISTS messages_recipient;
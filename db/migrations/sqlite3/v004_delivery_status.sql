@@ -0,0 +1,6 @@
+-- +migrate Up
+-- delivered_at is set once the message has been fetched or pushed to the recipient
+ALTER TABLE 'messages' ADD COLUMN delivered_at DATETIME;
+
+-- +migrate Down
+-- SQLite can't drop the delivered_at column, it is left in place
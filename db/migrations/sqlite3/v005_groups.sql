@@ -0,0 +1,28 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS 'groups' (
+	group_id INTEGER PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	owner_id INTEGER NOT NULL,
+	created_on DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(owner_id) REFERENCES users(uid)
+);
+
+CREATE TABLE IF NOT EXISTS 'group_members' (
+	group_id INTEGER NOT NULL,
+	uid INTEGER NOT NULL,
+	joined_on DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (group_id, uid),
+	FOREIGN KEY(group_id) REFERENCES groups(group_id),
+	FOREIGN KEY(uid) REFERENCES users(uid)
+);
+
+-- group messages are stored once with group_id set and no recipient_id
+ALTER TABLE 'messages' ADD COLUMN group_id INTEGER REFERENCES groups(group_id);
+
+CREATE INDEX IF NOT EXISTS messages_group ON messages (group_id);
+
+-- +migrate Down
+-- SQLite can't drop the group_id column, it is left in place
+DROP INDEX IF EXISTS messages_group;
+DROP TABLE IF EXISTS 'group_members';
+DROP TABLE IF EXISTS 'groups';
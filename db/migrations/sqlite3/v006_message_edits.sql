@@ -0,0 +1,7 @@
+-- +migrate Up
+-- edited messages keep their msg_id, deleted messages keep their row as a tombstone
+ALTER TABLE 'messages' ADD COLUMN edited_at DATETIME;
+ALTER TABLE 'messages' ADD COLUMN deleted_at DATETIME;
+
+-- +migrate Down
+-- SQLite can't drop the edited_at and deleted_at columns, they are left in place
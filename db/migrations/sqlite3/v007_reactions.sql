@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS 'reactions' (
+	msg_id INTEGER NOT NULL,
+	uid INTEGER NOT NULL,
+	emoji VARCHAR(32) NOT NULL,
+	created_on DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (msg_id, uid, emoji),
+	FOREIGN KEY(msg_id) REFERENCES messages(msg_id),
+	FOREIGN KEY(uid) REFERENCES users(uid)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS 'reactions';
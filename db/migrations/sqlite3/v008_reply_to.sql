@@ -0,0 +1,6 @@
+-- +migrate Up
+-- a reply references the message it quotes, in the same conversation
+ALTER TABLE 'messages' ADD COLUMN reply_to INTEGER REFERENCES messages(msg_id);
+
+-- +migrate Down
+-- SQLite can't drop the reply_to column, it is left in place
@@ -0,0 +1,31 @@
+-- +migrate Up
+-- full-text index of texts, rowid is the msg_id. FTS5 needs a build with the sqlite_fts5 tag
+CREATE VIRTUAL TABLE IF NOT EXISTS texts_fts USING fts5(msg, content='texts', content_rowid='msg_id');
+
+-- +migrate StatementBegin
+CREATE TRIGGER IF NOT EXISTS texts_fts_insert AFTER INSERT ON texts BEGIN
+	INSERT INTO texts_fts (rowid, msg) VALUES (new.msg_id, new.msg);
+END;
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+CREATE TRIGGER IF NOT EXISTS texts_fts_delete AFTER DELETE ON texts BEGIN
+	INSERT INTO texts_fts (texts_fts, rowid, msg) VALUES ('delete', old.msg_id, old.msg);
+END;
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+CREATE TRIGGER IF NOT EXISTS texts_fts_update AFTER UPDATE ON texts BEGIN
+	INSERT INTO texts_fts (texts_fts, rowid, msg) VALUES ('delete', old.msg_id, old.msg);
+	INSERT INTO texts_fts (rowid, msg) VALUES (new.msg_id, new.msg);
+END;
+-- +migrate StatementEnd
+
+-- index the texts stored before the migration
+INSERT INTO texts_fts (texts_fts) VALUES ('rebuild');
+
+-- +migrate Down
+DROP TRIGGER IF EXISTS texts_fts_update;
+DROP TRIGGER IF EXISTS texts_fts_delete;
+DROP TRIGGER IF EXISTS texts_fts_insert;
+DROP TABLE IF EXISTS texts_fts;
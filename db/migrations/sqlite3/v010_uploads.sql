@@ -0,0 +1,22 @@
+-- +migrate Up
+-- files uploaded by users, stored on disk under the configured upload_dir by upload_id
+CREATE TABLE IF NOT EXISTS 'uploads' (
+	upload_id VARCHAR(32) PRIMARY KEY NOT NULL,
+	uid INTEGER NOT NULL,
+	mime_type TEXT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	size INTEGER NOT NULL,
+	created_on DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(uid) REFERENCES users(uid)
+);
+
+-- image messages sent from an upload
+ALTER TABLE 'images' ADD COLUMN upload_id VARCHAR(32) REFERENCES uploads(upload_id);
+
+CREATE INDEX IF NOT EXISTS images_upload ON images (upload_id);
+
+-- +migrate Down
+-- SQLite can't drop the upload_id column, it is left in place
+DROP INDEX IF EXISTS images_upload;
+DROP TABLE IF EXISTS 'uploads';
@@ -0,0 +1,14 @@
+-- +migrate Up
+-- downscaled copies of the image of a message, stored on disk by msg_id and size
+CREATE TABLE IF NOT EXISTS 'thumbnails' (
+	msg_id INTEGER NOT NULL,
+	size INTEGER NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	created_on DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (msg_id, size),
+	FOREIGN KEY(msg_id) REFERENCES messages(msg_id)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS 'thumbnails';
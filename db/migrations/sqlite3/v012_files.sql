@@ -0,0 +1,27 @@
+-- +migrate Up
+REPLACE INTO messageType (mtype) VALUES ('file');
+
+-- name the file was uploaded with and checksum of its content
+ALTER TABLE 'uploads' ADD COLUMN filename TEXT NOT NULL DEFAULT '';
+ALTER TABLE 'uploads' ADD COLUMN sha256 VARCHAR(64) NOT NULL DEFAULT '';
+
+-- file messages, always sent from an upload
+CREATE TABLE IF NOT EXISTS 'files' (
+	msg_id INTEGER PRIMARY KEY NOT NULL,
+	upload_id VARCHAR(32) NOT NULL,
+	f_url TEXT NOT NULL,
+	filename TEXT NOT NULL,
+	mime_type TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	sha256 VARCHAR(64) NOT NULL,
+	FOREIGN KEY(msg_id) REFERENCES messages(msg_id),
+	FOREIGN KEY(upload_id) REFERENCES uploads(upload_id)
+);
+
+CREATE INDEX IF NOT EXISTS files_upload ON files (upload_id);
+
+-- +migrate Down
+-- SQLite can't drop the filename and sha256 columns, they are left in place
+DROP INDEX IF EXISTS files_upload;
+DROP TABLE IF EXISTS 'files';
+DELETE FROM messageType WHERE mtype = 'file';
@@ -0,0 +1,16 @@
+-- +migrate Up
+REPLACE INTO messageType (mtype) VALUES ('audio');
+
+-- audio messages, the waveform is one byte per sample
+CREATE TABLE IF NOT EXISTS 'audios' (
+	msg_id INTEGER PRIMARY KEY NOT NULL,
+	a_url TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL,
+	codec TEXT NOT NULL,
+	waveform BLOB,
+	FOREIGN KEY(msg_id) REFERENCES messages(msg_id)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS 'audios';
+DELETE FROM messageType WHERE mtype = 'audio';
@@ -0,0 +1,17 @@
+-- +migrate Up
+REPLACE INTO messageType (mtype) VALUES ('location');
+
+-- location messages, live locations have the time until which they can be moved
+CREATE TABLE IF NOT EXISTS 'locations' (
+	msg_id INTEGER PRIMARY KEY NOT NULL,
+	latitude REAL NOT NULL,
+	longitude REAL NOT NULL,
+	accuracy REAL NOT NULL DEFAULT 0,
+	label TEXT NOT NULL DEFAULT '',
+	live_until DATETIME,
+	FOREIGN KEY(msg_id) REFERENCES messages(msg_id)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS 'locations';
+DELETE FROM messageType WHERE mtype = 'location';
@@ -0,0 +1,92 @@
+package linkpreview
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+var errorFetchAddress = errors.New("error address is not public")
+
+// NewPublicClient returns an http client that only connects to public addresses, so links
+// sent by users can't reach the server itself or the private network it runs in. The check
+// is made on the address dialed, after resolution and on every redirect
+func NewPublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !IsPublicIP(ip) {
+				return errorFetchAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+	}
+}
+
+// networks that are not on the internet or that lead back into a local network
+var privateNetworks = parseNetworks(
+	// "this network", 0.0.0.0 reaches the host itself
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	// IETF protocol assignments
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	// benchmarking
+	"198.18.0.0/15",
+	// reserved, with the broadcast address 255.255.255.255
+	"240.0.0.0/4",
+	// IPv4 compatible, NAT64, local use NAT64, Teredo and 6to4 addresses embed an IPv4
+	// address, which can be private
+	"::/96",
+	"64:ff9b::/96",
+	"64:ff9b:1::/48",
+	"2001::/32",
+	"2002::/16",
+	"fc00::/7",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// IsPublicIP reports whether ip is reachable on the internet, loopback, link local,
+// private and reserved addresses are not
+func IsPublicIP(ip net.IP) bool {
+	// IPv4 mapped addresses, ::ffff:0:0/96, are checked as the IPv4 address they carry
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package linkpreview
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	addresses := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"8.8.8.8", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"::", false},
+		{"10.1.2.3", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"172.16.0.1", false},
+		{"192.0.0.8", false},
+		{"192.168.1.1", false},
+		{"198.18.0.1", false},
+		{"198.19.255.255", false},
+		{"224.0.0.1", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:8.8.8.8", true},
+		{"::a00:1", false},
+		{"::7f00:1", false},
+		{"64:ff9b::a00:1", false},
+		{"64:ff9b:1::a00:1", false},
+		{"2001:0:4136:e378:8000:63bf:3fff:fdd2", false},
+		{"2002:a00:1::", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"ff02::1", false},
+	}
+	for _, address := range addresses {
+		ip := net.ParseIP(address.ip)
+		if ip == nil {
+			t.Fatalf("invalid address %s", address.ip)
+		}
+		if IsPublicIP(ip) != address.public {
+			t.Errorf("%s: expected public %v", address.ip, address.public)
+		}
+	}
+}
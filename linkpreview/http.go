@@ -0,0 +1,162 @@
+package linkpreview
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	fetchTimeout = 5 * time.Second
+	// most of a page read looking for its preview, the head is at the start of the page
+	maxPageSize    = 512 << 10
+	maxRedirects   = 3
+	maxTitle       = 200
+	maxDescription = 500
+)
+
+// HTTPFetcher fetches previews from the OpenGraph tags of html pages, through a client
+// that only connects to public addresses
+type HTTPFetcher struct {
+	Client *http.Client
+}
+
+func NewHTTPFetcher() *HTTPFetcher {
+	return newHTTPFetcher(NewPublicClient(fetchTimeout))
+}
+
+// fetcher through client, following at most maxRedirects redirects to http urls
+func newHTTPFetcher(client *http.Client) *HTTPFetcher {
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return errors.New("redirect to a scheme other than http")
+		}
+		return nil
+	}
+	return &HTTPFetcher{Client: client}
+}
+
+func (f *HTTPFetcher) Fetch(rawUrl string) (Preview, error) {
+	pageUrl, err := url.Parse(rawUrl)
+	if err != nil || (pageUrl.Scheme != "http" && pageUrl.Scheme != "https") {
+		return Preview{}, errorNoPreview
+	}
+	req, err := http.NewRequest("GET", pageUrl.String(), nil)
+	if err != nil {
+		return Preview{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return Preview{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Preview{}, fmt.Errorf("fetching preview returned %d", resp.StatusCode)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return Preview{}, errorNoPreview
+	}
+
+	preview := parseHead(io.LimitReader(resp.Body, maxPageSize))
+	// relative to the page the redirects ended on
+	preview.ImageUrl = resolveImage(resp.Request.URL, preview.ImageUrl)
+	preview.Url = rawUrl
+	if preview.Title == "" && preview.Description == "" && preview.ImageUrl == "" {
+		return Preview{}, errorNoPreview
+	}
+	return preview, nil
+}
+
+// read the og: meta tags of the head of a page, the title tag and description meta tag
+// are used when the page has no og:title or og:description
+func parseHead(r io.Reader) Preview {
+	var preview Preview
+	var title, description string
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return finishPreview(preview, title, description)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			switch token.Data {
+			case "body":
+				return finishPreview(preview, title, description)
+			case "title":
+				if z.Next() == html.TextToken && title == "" {
+					title = string(z.Text())
+				}
+			case "meta":
+				var key, value string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "property", "name":
+						key = strings.ToLower(attr.Val)
+					case "content":
+						value = attr.Val
+					}
+				}
+				switch key {
+				case "og:title":
+					preview.Title = value
+				case "og:description":
+					preview.Description = value
+				case "og:image", "og:image:url", "og:image:secure_url":
+					if preview.ImageUrl == "" {
+						preview.ImageUrl = value
+					}
+				case "description":
+					description = value
+				}
+			}
+		case html.EndTagToken:
+			if z.Token().Data == "head" {
+				return finishPreview(preview, title, description)
+			}
+		}
+	}
+}
+
+func finishPreview(preview Preview, title string, description string) Preview {
+	if preview.Title == "" {
+		preview.Title = title
+	}
+	if preview.Description == "" {
+		preview.Description = description
+	}
+	preview.Title = truncate(strings.Join(strings.Fields(preview.Title), " "), maxTitle)
+	preview.Description = truncate(strings.Join(strings.Fields(preview.Description), " "), maxDescription)
+	return preview
+}
+
+// absolute http url of the image, images of other schemes are dropped
+func resolveImage(pageUrl *url.URL, image string) string {
+	if image == "" {
+		return ""
+	}
+	imageUrl, err := pageUrl.Parse(strings.TrimSpace(image))
+	if err != nil || (imageUrl.Scheme != "http" && imageUrl.Scheme != "https") {
+		return ""
+	}
+	return imageUrl.String()
+}
+
+// cut s to at most n characters, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "…"
+}
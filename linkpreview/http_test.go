@@ -0,0 +1,83 @@
+package linkpreview
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchPageSize(t *testing.T) {
+	padding := strings.Repeat(" ", maxPageSize)
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/small":
+			fmt.Fprint(w, `<html><head><meta property="og:title" content="Small"></head></html>`)
+		case "/late":
+			// the title comes after the part of the page that is read
+			fmt.Fprint(w, `<html><head>`+padding+`<meta property="og:title" content="Late"></head></html>`)
+		case "/endless":
+			// a head that never ends, the fetcher has to stop reading
+			fmt.Fprint(w, `<html><head>`)
+			for {
+				_, err := fmt.Fprint(w, `<meta name="filler" content="filler">`)
+				if err != nil {
+					return
+				}
+			}
+		}
+	}))
+	defer page.Close()
+	fetcher := newHTTPFetcher(&http.Client{})
+
+	preview, err := fetcher.Fetch(page.URL + "/small")
+	if err != nil || preview.Title != "Small" {
+		t.Fatalf("unexpected preview %v %v", preview, err)
+	}
+	_, err = fetcher.Fetch(page.URL + "/late")
+	if err != errorNoPreview {
+		t.Fatalf("expected %v, got %v", errorNoPreview, err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := fetcher.Fetch(page.URL + "/endless")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != errorNoPreview {
+			t.Fatalf("expected %v, got %v", errorNoPreview, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetching an endless page did not stop")
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	if NewHTTPFetcher().Client.Timeout != fetchTimeout {
+		t.Fatal("fetcher without a timeout")
+	}
+
+	release := make(chan struct{})
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer page.Close()
+	defer close(release)
+
+	fetcher := newHTTPFetcher(&http.Client{Timeout: 100 * time.Millisecond})
+	start := time.Now()
+	_, err := fetcher.Fetch(page.URL)
+	if err == nil {
+		t.Fatal("fetching a page that never answers succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("fetch gave up after %s", elapsed)
+	}
+}
@@ -0,0 +1,53 @@
+// Package linkpreview fetches the OpenGraph title, description and image of the links
+// sent in messages. Fetching goes through a Fetcher, HTTPFetcher on the web and Fake in tests
+package linkpreview
+
+import (
+	"errors"
+	"sync"
+)
+
+const ErrorNoPreview = "error link has no preview"
+
+var errorNoPreview = errors.New(ErrorNoPreview)
+
+// Preview of a link, any of the fields but Url can be empty
+type Preview struct {
+	Url         string
+	Title       string
+	Description string
+	ImageUrl    string
+}
+
+// Fetcher fetches the preview of a link
+type Fetcher interface {
+	Fetch(url string) (Preview, error)
+}
+
+// Fake is a Fetcher answering from previews added to it, other links have no preview
+type Fake struct {
+	mu       sync.Mutex
+	previews map[string]Preview
+}
+
+func NewFake() *Fake {
+	return &Fake{previews: make(map[string]Preview)}
+}
+
+// make url return preview
+func (f *Fake) Add(url string, preview Preview) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	preview.Url = url
+	f.previews[url] = preview
+}
+
+func (f *Fake) Fetch(url string) (Preview, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	preview, found := f.previews[url]
+	if !found {
+		return Preview{}, errorNoPreview
+	}
+	return preview, nil
+}
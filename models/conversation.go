@@ -0,0 +1,142 @@
+package models
+
+import (
+	"log"
+)
+
+// Conversation is the latest message exchanged with another user and the
+// number of messages from that user not yet read
+type Conversation struct {
+	UserID      int
+	Username    string
+	LastMessage Message
+	Unread      int
+}
+
+// get the conversations of user uid, most recent first
+func (dao *DAO) GetConversations(uid int, limit int, offset int) ([]Conversation, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		log.Println("error starting Tx", err.Error())
+		return nil, err
+	}
+
+	// latest message per counterpart, messages ids grow with time
+	query := `SELECT ` + messageColumns + `, c.counterpart_id, users.username,
+				(SELECT COUNT(*) FROM messages AS unread
+				 WHERE unread.sender_id = c.counterpart_id AND unread.recipient_id = ? AND unread.read_at IS NULL)
+			  FROM (SELECT CASE WHEN sender_id = ? THEN recipient_id ELSE sender_id END AS counterpart_id,
+						MAX(msg_id) AS last_msg_id
+					FROM messages
+					WHERE (sender_id = ? OR recipient_id = ?) AND group_id IS NULL
+					GROUP BY counterpart_id) AS c
+			  JOIN users ON users.uid = c.counterpart_id
+			  JOIN messages ON messages.msg_id = c.last_msg_id
+			  ORDER BY c.last_msg_id DESC
+			  LIMIT ? OFFSET ?`
+
+	res, err := tx.Query(query, uid, uid, uid, uid, limit, offset)
+	if err != nil {
+		tx.Rollback()
+		log.Println("error retrieving conversations", err.Error())
+		return nil, err
+	}
+	defer res.Close()
+
+	conversations := []Conversation{}
+	for res.Next() {
+		var conversation Conversation
+		err := scanMessage(res, &conversation.LastMessage, &conversation.UserID, &conversation.Username, &conversation.Unread)
+		if err != nil {
+			tx.Rollback()
+			log.Println("error scanning conversations", err.Error())
+			return nil, err
+		}
+		conversations = append(conversations, conversation)
+	}
+	err = res.Err()
+	if err != nil {
+		tx.Rollback()
+		log.Println("error occured during iteration", err.Error())
+		return nil, err
+	}
+	lastMessages := make([]Message, len(conversations))
+	for i, conversation := range conversations {
+		lastMessages[i] = conversation.LastMessage
+	}
+	err = loadContent(tx, lastMessages)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for i := range conversations {
+		conversations[i].LastMessage = lastMessages[i]
+	}
+	tx.Commit()
+	return conversations, nil
+}
+
+// get messages exchanged between uid and other_id in both directions, ordered by msg_id.
+// Only messages newer than after and older than before are returned when set, without
+// after the latest page is returned
+func (dao *DAO) GetConversationMessages(uid int, other_id int, before int, after int, limit int) ([]Message, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		log.Println("error starting Tx", err.Error())
+		return nil, err
+	}
+
+	query := `SELECT ` + messageColumns + `
+			  FROM messages
+			  WHERE ((sender_id = ? AND recipient_id = ?) OR (sender_id = ? AND recipient_id = ?))`
+	args := []interface{}{uid, other_id, other_id, uid}
+	if before > 0 {
+		query += " AND messages.msg_id < ?"
+		args = append(args, before)
+	}
+	order := "DESC"
+	if after > 0 {
+		query += " AND messages.msg_id > ?"
+		args = append(args, after)
+		order = "ASC"
+	}
+	query += " ORDER BY messages.msg_id " + order + " LIMIT ?"
+	args = append(args, limit)
+
+	res, err := tx.Query(query, args...)
+	if err != nil {
+		tx.Rollback()
+		log.Println("error retrieving conversation messages", err.Error())
+		return nil, err
+	}
+	defer res.Close()
+
+	msgs := []Message{}
+	for res.Next() {
+		var msg Message
+		err := scanMessage(res, &msg)
+		if err != nil {
+			tx.Rollback()
+			log.Println("error scanning messages", err.Error())
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	err = res.Err()
+	if err != nil {
+		tx.Rollback()
+		log.Println("error occured during iteration", err.Error())
+		return nil, err
+	}
+	err = loadContent(tx, msgs)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	tx.Commit()
+
+	if after <= 0 {
+		reverseMessages(msgs)
+	}
+	return msgs, nil
+}
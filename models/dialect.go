@@ -0,0 +1,116 @@
+package models
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// dialect is what differs between the SQL of the supported databases. Queries are written
+// for SQLite, with ? placeholders, and rewritten for the database they run on
+type dialect struct {
+	// name of the database/sql driver and of the sql-migrate dialect
+	name string
+	// directory of the migrations creating the schema in this database, in the migrations
+	// directory given to RunMigrations
+	migrationsDir string
+	// placeholders are numbered, $1, $2...
+	numberedPlaceholders bool
+	// generated ids are returned by the INSERT instead of read with LastInsertId
+	returning bool
+}
+
+var dialects = map[string]dialect{
+	"sqlite3": {
+		name:          "sqlite3",
+		migrationsDir: "sqlite3",
+	},
+	"postgres": {
+		name:                 "postgres",
+		migrationsDir:        "postgres",
+		numberedPlaceholders: true,
+		returning:            true,
+	},
+}
+
+// rewrite the ? placeholders of query for the dialect, question marks in strings are kept
+func (d dialect) rebind(query string) string {
+	if !d.numberedPlaceholders || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			// a quote escaped by doubling it toggles twice
+			inString = !inString
+		case c == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// database runs queries written for SQLite in the dialect of the database
+type database struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (db *database) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db *database) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+func (db *database) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+func (db *database) Begin() (*transaction, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{tx, db.dialect}, nil
+}
+
+// transaction runs queries written for SQLite in the dialect of the database
+type transaction struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx *transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx *transaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx *transaction) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
+
+// run an INSERT and return the id the database generated for column
+func (tx *transaction) insert(query string, column string, args ...interface{}) (int, error) {
+	if tx.dialect.returning {
+		var id int
+		err := tx.QueryRow(query+" RETURNING "+column, args...).Scan(&id)
+		return id, err
+	}
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
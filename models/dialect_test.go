@@ -0,0 +1,158 @@
+package models
+
+import (
+	"fmt"
+	"github.com/dtsang7/ASAPP/content"
+	"github.com/rubenv/sql-migrate"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+Test Scenario:
+1. SQLite queries are run as written
+2. PostgreSQL queries get numbered placeholders, question marks in strings are kept
+*/
+func TestRebind(t *testing.T) {
+	queries := []struct {
+		dialect string
+		query   string
+		bound   string
+	}{
+		{"sqlite3", "SELECT uid FROM users WHERE username = ?", "SELECT uid FROM users WHERE username = ?"},
+		{"postgres", "SELECT uid FROM users WHERE username = ?", "SELECT uid FROM users WHERE username = $1"},
+		{"postgres", "INSERT INTO t (a, b) VALUES (?, ?) ON CONFLICT DO NOTHING", "INSERT INTO t (a, b) VALUES ($1, $2) ON CONFLICT DO NOTHING"},
+		{"postgres", "SELECT '?', 'it''s ?' FROM t WHERE a = ? AND b IN (?, ?)", "SELECT '?', 'it''s ?' FROM t WHERE a = $1 AND b IN ($2, $3)"},
+		{"postgres", "SELECT 1", "SELECT 1"},
+	}
+	for _, q := range queries {
+		bound := dialects[q.dialect].rebind(q.query)
+		if bound != q.bound {
+			t.Fatalf("%s: %s != %s", q.dialect, bound, q.bound)
+		}
+	}
+}
+
+/*
+Test Scenario:
+1. Every dialect has a directory of migrations, readable by sql-migrate
+2. Every dialect has the same migration ids, a change is one migration in every directory
+3. Every migration changes the schema when applied
+4. The migrations of every dialect create a table for every content type
+*/
+func TestDialectMigrations(t *testing.T) {
+	var names []string
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var ids []string
+	for _, name := range names {
+		d := dialects[name]
+		migrations, err := migrate.FileMigrationSource{Dir: filepath.Join("..", MigrationsDir, d.migrationsDir)}.FindMigrations()
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if len(migrations) == 0 {
+			t.Fatalf("%s has no migrations", name)
+		}
+
+		var dialectIDs []string
+		for _, m := range migrations {
+			dialectIDs = append(dialectIDs, m.Id)
+			if len(m.Up) == 0 {
+				t.Fatalf("%s migration %s changes nothing", name, m.Id)
+			}
+		}
+		if ids == nil {
+			ids = dialectIDs
+		} else if !reflect.DeepEqual(dialectIDs, ids) {
+			t.Fatalf("%s migrations %v differ from %v", name, dialectIDs, ids)
+		}
+
+		for _, mtype := range content.Names() {
+			found := false
+			for _, m := range migrations {
+				for _, statement := range m.Up {
+					if strings.Contains(statement, "'"+mtype+"'") {
+						found = true
+					}
+				}
+			}
+			if !found {
+				t.Fatalf("%s migrations don't add the %s message type", name, mtype)
+			}
+		}
+	}
+}
+
+/*
+Test Scenario:
+1. Runs only when ASAPP_TEST_POSTGRES is the connection string of a database it can write
+2. The migrations are applied, users, groups and messages are created with generated ids
+3. Messages are read back with their content and found by search
+4. The DAO passes the conformance tests of stores
+*/
+func TestPostgres(t *testing.T) {
+	dataSource := os.Getenv("ASAPP_TEST_POSTGRES")
+	if dataSource == "" {
+		t.Skip("ASAPP_TEST_POSTGRES is not set")
+	}
+	dao := migratedDAO("postgres", dataSource)
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	user1, err := dao.CreateUser(User{Username: "pg1_" + suffix, Password: "test_password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user2, err := dao.CreateUser(User{Username: "pg2_" + suffix, Password: "test_password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user1 <= 0 || user2 <= user1 {
+		t.Fatalf("unexpected user ids %d %d", user1, user2)
+	}
+	groupID, err := dao.CreateGroup(user1, "group", []int{user2})
+	if err != nil || groupID <= 0 {
+		t.Fatal("group not created", err)
+	}
+
+	text := "postgres zebracorn " + suffix
+	msgID, timeStamp, err := dao.SendMessage(Message{SenderID: user1, RecipientID: user2, Type: "text", Content: content.Content{Type: "text", Payload: content.Text{Text: text}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := time.Parse(time.RFC3339, timeStamp); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = dao.SendMessage(Message{SenderID: user2, GroupID: groupID, Type: "image", Content: content.Content{Type: "image", Payload: content.Image{Width: 1, Height: 1, Url: "https://example.com/a.png"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := dao.GetMessages(user2, user2, msgID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Content.Payload.(content.Text).Text != text || msgs[0].TimeStamp != timeStamp {
+		t.Fatalf("unexpected messages %v", msgs)
+	}
+	conversations, err := dao.GetConversations(user1, 10, 0)
+	if err != nil || len(conversations) != 1 || conversations[0].LastMessage.MsgID != msgID {
+		t.Fatalf("unexpected conversations %v %v", conversations, err)
+	}
+	results, err := dao.SearchMessages(user2, "zebracorn "+suffix, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Message.MsgID != msgID {
+		t.Fatalf("unexpected search results %v", results)
+	}
+
+	testStore(t, dao)
+}
@@ -0,0 +1,213 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/dtsang7/ASAPP/content"
+	"log"
+)
+
+type Group struct {
+	GroupID   int
+	Name      string
+	OwnerID   int
+	CreatedOn string
+}
+
+type GroupMember struct {
+	UserID   int
+	Username string
+	JoinedOn string
+}
+
+const (
+	ErrorGroupDoesNotExist = "Group does not exist"
+	ErrorOwnerCannotLeave  = "Group owner can't leave the group"
+)
+
+var errGroupDoesNotExist = errors.New(ErrorGroupDoesNotExist)
+var errOwnerCannotLeave = errors.New(ErrorOwnerCannotLeave)
+
+// create group owned by owner_id, the owner and member_ids become members
+func (dao *DAO) CreateGroup(owner_id int, name string, member_ids []int) (int, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		log.Println("error starting Tx", err.Error())
+		return 0, err
+	}
+
+	query := "INSERT INTO groups (name, owner_id) VALUES (?, ?)"
+	groupID, err := tx.insert(query, "group_id", name, owner_id)
+	if err != nil {
+		tx.Rollback()
+		log.Println("error inserting group", err.Error())
+		return 0, err
+	}
+
+	for _, uid := range append([]int{owner_id}, member_ids...) {
+		err = addGroupMember(tx, groupID, uid)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	tx.Commit()
+	return groupID, nil
+}
+
+// get group by id
+func (dao *DAO) GetGroup(group_id int) (Group, error) {
+	var group Group
+	query := "SELECT group_id, name, owner_id, created_on FROM groups WHERE group_id = ?"
+	err := dao.db.QueryRow(query, group_id).Scan(&group.GroupID, &group.Name, &group.OwnerID, content.RequiredTimestamp(&group.CreatedOn))
+	if err == sql.ErrNoRows {
+		return group, errGroupDoesNotExist
+	}
+	if err != nil {
+		log.Println("error retrieving group", err.Error())
+		return group, err
+	}
+	return group, nil
+}
+
+// check if uid is a member of group_id
+func (dao *DAO) IsGroupMember(group_id int, uid int) (bool, error) {
+	var member bool
+	query := "SELECT EXISTS (SELECT uid FROM group_members WHERE group_id = ? AND uid = ?)"
+	err := dao.db.QueryRow(query, group_id, uid).Scan(&member)
+	if err != nil {
+		log.Println("error checking group membership", err.Error())
+		return false, err
+	}
+	return member, nil
+}
+
+// add uid to group_id, adding an existing member does nothing
+func (dao *DAO) AddGroupMember(group_id int, uid int) error {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		log.Println("error starting Tx", err.Error())
+		return err
+	}
+	err = addGroupMember(tx, group_id, uid)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func addGroupMember(tx *transaction, group_id int, uid int) error {
+	var exist bool
+	query := "SELECT EXISTS (SELECT uid FROM users WHERE uid = ?)"
+	err := tx.QueryRow(query, uid).Scan(&exist)
+	if err != nil {
+		log.Println("error checking if user exist", err.Error())
+		return err
+	}
+	if !exist {
+		log.Println(errUserDoesNotExist.Error())
+		return errUserDoesNotExist
+	}
+
+	query = "INSERT INTO group_members (group_id, uid) VALUES (?, ?) ON CONFLICT DO NOTHING"
+	_, err = tx.Exec(query, group_id, uid)
+	if err != nil {
+		log.Println("error inserting group member", err.Error())
+		return err
+	}
+	return nil
+}
+
+// remove uid from group_id, the owner can't be removed
+func (dao *DAO) RemoveGroupMember(group_id int, uid int) error {
+	// a group without its owner would be left without anyone to manage it
+	query := "DELETE FROM group_members WHERE group_id = ? AND uid = ? AND uid <> (SELECT owner_id FROM groups WHERE group_id = ?)"
+	res, err := dao.db.Exec(query, group_id, uid, group_id)
+	if err != nil {
+		log.Println("error removing group member", err.Error())
+		return err
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		group, err := dao.GetGroup(group_id)
+		if err == nil && group.OwnerID == uid {
+			log.Println(errOwnerCannotLeave.Error())
+			return errOwnerCannotLeave
+		}
+	}
+	return nil
+}
+
+// get members of group_id in the order they joined
+func (dao *DAO) GetGroupMembers(group_id int) ([]GroupMember, error) {
+	query := `SELECT users.uid, users.username, group_members.joined_on
+			  FROM group_members
+			  JOIN users ON users.uid = group_members.uid
+			  WHERE group_id = ?
+			  ORDER BY group_members.joined_on, users.uid`
+	res, err := dao.db.Query(query, group_id)
+	if err != nil {
+		log.Println("error retrieving group members", err.Error())
+		return nil, err
+	}
+	defer res.Close()
+
+	members := []GroupMember{}
+	for res.Next() {
+		var member GroupMember
+		err := res.Scan(&member.UserID, &member.Username, content.RequiredTimestamp(&member.JoinedOn))
+		if err != nil {
+			log.Println("error scanning group members", err.Error())
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	err = res.Err()
+	if err != nil {
+		log.Println("error occured during iteration", err.Error())
+		return nil, err
+	}
+	return members, nil
+}
+
+// get messages sent to group_id starting at msg_id
+func (dao *DAO) GetGroupMessages(group_id int, msg_id int, limit int) ([]Message, error) {
+	query := `SELECT ` + messageColumns + `
+			  FROM messages
+			  WHERE group_id = ? AND messages.msg_id >= ?
+			  ORDER BY messages.msg_id
+			  LIMIT ?`
+	res, err := dao.db.Query(query, group_id, msg_id, limit)
+	if err != nil {
+		log.Println("error retrieving group messages", err.Error())
+		return nil, err
+	}
+	defer res.Close()
+
+	msgs := []Message{}
+	for res.Next() {
+		var msg Message
+		err := scanMessage(res, &msg)
+		if err != nil {
+			log.Println("error scanning messages", err.Error())
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	err = res.Err()
+	if err != nil {
+		log.Println("error occured during iteration", err.Error())
+		return nil, err
+	}
+	err = loadContent(dao.db, msgs)
+	if err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
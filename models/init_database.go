@@ -2,32 +2,40 @@ package models
 
 import (
 	"database/sql"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/rubenv/sql-migrate"
 	"log"
+	"path/filepath"
 )
 
+// MigrationsDir is the directory of the migrations of every database, relative to the root
+// of the repository
+const MigrationsDir = "db/migrations"
+
 type DAO struct {
-	db         *sql.DB
-	driverName string
+	db *database
 }
 
+// driverName is sqlite3 or postgres, for postgres dataSource is a connection string
 func CreateDAO(driverName string, dataSource string) *DAO {
+	d, found := dialects[driverName]
+	if !found {
+		log.Fatal("Unsupported DB driver ", driverName)
+	}
 	//connect to database
 	db, err := sql.Open(driverName, dataSource)
 	if err != nil {
 		log.Fatal("Unable to open DB", err.Error())
 	}
 	//database to struct
-	return &DAO{db, driverName}
+	return &DAO{&database{db, d}}
 }
 
-func (dao *DAO) RunMigrations() {
-	migrations := migrate.FileMigrationSource{
-		Dir: "db/migrations",
-	}
-
-	n, err := migrate.Exec(dao.db, dao.driverName, migrations, migrate.Up)
+// apply the migrations of the database found in dir, MigrationsDir when run from the root
+func (dao *DAO) RunMigrations(dir string) {
+	migrations := migrate.FileMigrationSource{Dir: filepath.Join(dir, dao.db.dialect.migrationsDir)}
+	n, err := migrate.Exec(dao.db.DB, dao.db.dialect.name, migrations, migrate.Up)
 	if err != nil {
 		log.Fatal("Unable to migrate", err.Error())
 	}
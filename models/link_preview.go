@@ -0,0 +1,67 @@
+package models
+
+import (
+	"log"
+	"strings"
+)
+
+// LinkPreview is the preview of the first link of a text message, fetched after it is sent
+type LinkPreview struct {
+	MsgID       int
+	Url         string
+	Title       string
+	Description string
+	ImageUrl    string
+}
+
+// store the preview of a message, replacing the preview of the link it had before an edit
+func (dao *DAO) SetLinkPreview(preview LinkPreview) error {
+	query := `INSERT INTO link_previews (msg_id, url, title, description, image_url) VALUES (?, ?, ?, ?, ?)
+			  ON CONFLICT (msg_id) DO UPDATE SET url = excluded.url, title = excluded.title,
+			  	description = excluded.description, image_url = excluded.image_url`
+	_, err := dao.db.Exec(query, preview.MsgID, preview.Url, preview.Title, preview.Description, preview.ImageUrl)
+	if err != nil {
+		log.Println("error inserting link preview", err.Error())
+		return err
+	}
+	return nil
+}
+
+// get the link previews of msg_ids by message
+func (dao *DAO) GetLinkPreviews(msg_ids []int) (map[int]LinkPreview, error) {
+	previews := make(map[int]LinkPreview)
+	err := inChunks(msg_ids, func(ids []int) error {
+		query := `SELECT msg_id, url, title, description, image_url
+				  FROM link_previews
+				  WHERE msg_id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		var args []interface{}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		res, err := dao.db.Query(query, args...)
+		if err != nil {
+			log.Println("error retrieving link previews", err.Error())
+			return err
+		}
+		defer res.Close()
+
+		for res.Next() {
+			var preview LinkPreview
+			err := res.Scan(&preview.MsgID, &preview.Url, &preview.Title, &preview.Description, &preview.ImageUrl)
+			if err != nil {
+				log.Println("error scanning link previews", err.Error())
+				return err
+			}
+			previews[preview.MsgID] = preview
+		}
+		err = res.Err()
+		if err != nil {
+			log.Println("error occured during iteration", err.Error())
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return previews, nil
+}
@@ -0,0 +1,692 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/dtsang7/ASAPP/content"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errorUploadExists = errors.New("Upload already exists")
+
+// MemoryStore is a Store keeping everything in memory, safe for concurrent use. Its data
+// is lost when the process exits
+type MemoryStore struct {
+	mu sync.RWMutex
+	// users and messages by id - 1, ids start at 1 and are never reused
+	users     []User
+	messages  []Message
+	groups    []Group
+	members   map[int][]GroupMember
+	tokens    map[string]time.Time
+	reactions []memoryReaction
+	uploads   map[string]Upload
+	// thumbnails by message and size
+	thumbnails map[int]map[int]Thumbnail
+	previews   map[int]LinkPreview
+}
+
+type memoryReaction struct {
+	msgID     int
+	uid       int
+	emoji     string
+	createdOn string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		members:    make(map[int][]GroupMember),
+		tokens:     make(map[string]time.Time),
+		uploads:    make(map[string]Upload),
+		thumbnails: make(map[int]map[int]Thumbnail),
+		previews:   make(map[int]LinkPreview),
+	}
+}
+
+// current time as the databases return CURRENT_TIMESTAMP
+func memoryTimestamp() string {
+	return time.Now().UTC().Format(content.TimestampFormat)
+}
+
+func memoryNullTimestamp() sql.NullString {
+	return sql.NullString{String: memoryTimestamp(), Valid: true}
+}
+
+func (m *MemoryStore) CheckDB() (int, error) {
+	return 1, nil
+}
+
+func (m *MemoryStore) CreateUser(usr User) (int, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(usr.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("error hashing password", err.Error())
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.users {
+		if existing.Username == usr.Username {
+			log.Println(errUserExist.Error())
+			return 0, errUserExist
+		}
+	}
+	id := len(m.users) + 1
+	m.users = append(m.users, User{Id: id, Username: usr.Username, Password: string(hashedPassword)})
+	return id, nil
+}
+
+func (m *MemoryStore) LoginUser(existingUser User) (int, error) {
+	m.mu.RLock()
+	var found User
+	for _, usr := range m.users {
+		if usr.Username == existingUser.Username {
+			found = usr
+		}
+	}
+	m.mu.RUnlock()
+	if found.Id == 0 {
+		log.Println(errUserDoesNotExist.Error())
+		return 0, errUserDoesNotExist
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(found.Password), []byte(existingUser.Password))
+	if err != nil {
+		log.Println("error comparing passwords", err.Error())
+		return 0, errWrongPassword
+	}
+	return found.Id, nil
+}
+
+// user with uid, nil when there is none. The lock must be held
+func (m *MemoryStore) user(uid int) *User {
+	if uid <= 0 || uid > len(m.users) {
+		return nil
+	}
+	return &m.users[uid-1]
+}
+
+// message with msg_id, nil when there is none. The lock must be held
+func (m *MemoryStore) message(msg_id int) *Message {
+	if msg_id <= 0 || msg_id > len(m.messages) {
+		return nil
+	}
+	return &m.messages[msg_id-1]
+}
+
+func (m *MemoryStore) SendMessage(msg Message) (int, string, error) {
+	if _, found := content.Lookup(msg.Type); !found {
+		log.Println(errorMessageTypeNotSupported.Error())
+		return 0, "", errorMessageTypeNotSupported
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg.MsgID = len(m.messages) + 1
+	msg.TimeStamp = memoryTimestamp()
+	msg.DeliveredAt, msg.ReadAt, msg.EditedAt, msg.DeletedAt = sql.NullString{}, sql.NullString{}, sql.NullString{}, sql.NullString{}
+	m.messages = append(m.messages, msg)
+	return msg.MsgID, msg.TimeStamp, nil
+}
+
+// messages matching keep in the order of their ids, from msg_id up to limit of them.
+// The lock must be held
+func (m *MemoryStore) messagesFrom(msg_id int, limit int, keep func(msg *Message) bool) []Message {
+	msgs := []Message{}
+	for i := range m.messages {
+		if len(msgs) >= limit {
+			break
+		}
+		if m.messages[i].MsgID >= msg_id && keep(&m.messages[i]) {
+			msgs = append(msgs, m.messages[i])
+		}
+	}
+	return msgs
+}
+
+func (m *MemoryStore) GetMessages(viewer_id int, recipient_id int, msg_id int, limit int) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.messagesFrom(msg_id, limit, func(msg *Message) bool {
+		return msg.RecipientID == recipient_id && (recipient_id == viewer_id || msg.SenderID == viewer_id)
+	}), nil
+}
+
+func (m *MemoryStore) GetReceivedMessages(uid int, msg_id int, limit int) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.messagesFrom(msg_id, limit, func(msg *Message) bool {
+		return msg.RecipientID == uid || (msg.SenderID != uid && msg.GroupID > 0 && m.isGroupMember(msg.GroupID, uid))
+	}), nil
+}
+
+func (m *MemoryStore) GetMessagesBefore(viewer_id int, recipient_id int, before int, limit int) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	msgs := []Message{}
+	for i := len(m.messages) - 1; i >= 0 && len(msgs) < limit; i-- {
+		msg := &m.messages[i]
+		if (before <= 0 || msg.MsgID < before) && msg.RecipientID == recipient_id && (recipient_id == viewer_id || msg.SenderID == viewer_id) {
+			msgs = append(msgs, *msg)
+		}
+	}
+	reverseMessages(msgs)
+	return msgs, nil
+}
+
+func (m *MemoryStore) RevokeToken(jti string, expiresOn time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// expired tokens are rejected by the jwt middleware, no need to remember them
+	now := time.Now()
+	for revoked, expires := range m.tokens {
+		if expires.Before(now) {
+			delete(m.tokens, revoked)
+		}
+	}
+	if _, found := m.tokens[jti]; found {
+		return false, nil
+	}
+	m.tokens[jti] = expiresOn
+	return true, nil
+}
+
+func (m *MemoryStore) IsTokenRevoked(jti string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, revoked := m.tokens[jti]
+	return revoked, nil
+}
+
+func (m *MemoryStore) MarkDelivered(recipient_id int, msg_ids []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range msg_ids {
+		msg := m.message(id)
+		if msg != nil && msg.RecipientID == recipient_id && !msg.DeliveredAt.Valid {
+			msg.DeliveredAt = memoryNullTimestamp()
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) MarkRead(recipient_id int, msg_id int, sender_id int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	marked := 0
+	for i := range m.messages {
+		msg := &m.messages[i]
+		if msg.RecipientID != recipient_id || msg.MsgID > msg_id || msg.ReadAt.Valid || (sender_id > 0 && msg.SenderID != sender_id) {
+			continue
+		}
+		msg.ReadAt = memoryNullTimestamp()
+		if !msg.DeliveredAt.Valid {
+			msg.DeliveredAt = msg.ReadAt
+		}
+		marked++
+	}
+	return marked, nil
+}
+
+func (m *MemoryStore) GetMessage(msg_id int) (Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	msg := m.message(msg_id)
+	if msg == nil {
+		return Message{}, errorMessageDoesNotExist
+	}
+	return *msg, nil
+}
+
+func (m *MemoryStore) GetMessagesByID(msg_ids []int) (map[int]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	msgs := make(map[int]Message)
+	for _, id := range msg_ids {
+		if msg := m.message(id); msg != nil {
+			msgs[id] = *msg
+		}
+	}
+	return msgs, nil
+}
+
+func (m *MemoryStore) EditMessage(msg_id int, c content.Content) error {
+	t, found := content.Lookup(c.Type)
+	updater, ok := t.(content.Updater)
+	if !found || !ok {
+		log.Println(errorMessageTypeNotSupported.Error())
+		return errorMessageTypeNotSupported
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg := m.message(msg_id)
+	if msg == nil {
+		return errorMessageDoesNotExist
+	}
+	merged, err := updater.Merge(msg.Content.Payload, c.Payload)
+	if err != nil {
+		return err
+	}
+	msg.Content.Payload = merged
+	msg.EditedAt = memoryNullTimestamp()
+	return nil
+}
+
+func (m *MemoryStore) DeleteMessage(msg_id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg := m.message(msg_id)
+	if msg == nil {
+		log.Println(errorMessageDoesNotExist.Error())
+		return errorMessageDoesNotExist
+	}
+	msg.Content = content.Content{}
+	msg.DeletedAt = memoryNullTimestamp()
+
+	reactions := m.reactions[:0]
+	for _, reaction := range m.reactions {
+		if reaction.msgID != msg_id {
+			reactions = append(reactions, reaction)
+		}
+	}
+	m.reactions = reactions
+	delete(m.thumbnails, msg_id)
+	delete(m.previews, msg_id)
+	return nil
+}
+
+// whether uid can read msg, as sender, recipient or member of its group. The lock must be held
+func (m *MemoryStore) canRead(msg *Message, uid int) bool {
+	if msg.SenderID == uid || msg.RecipientID == uid {
+		return true
+	}
+	return msg.GroupID > 0 && m.isGroupMember(msg.GroupID, uid)
+}
+
+// Every word of query has to be a word of the text, the snippet is the whole text with
+// the matching words highlighted
+func (m *MemoryStore) SearchMessages(uid int, query string, limit int, offset int) ([]SearchResult, error) {
+	terms := searchTerms(query)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	results := []SearchResult{}
+	if len(terms) == 0 {
+		return results, nil
+	}
+	for i := len(m.messages) - 1; i >= 0 && len(results) < limit; i-- {
+		msg := &m.messages[i]
+		t, ok := msg.Content.Payload.(content.Text)
+		if !ok || !m.canRead(msg, uid) || !containsTerms(t.Text, terms) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		results = append(results, SearchResult{Message: *msg, Snippet: snippet(t.Text, terms)})
+	}
+	return results, nil
+}
+
+// whether every word of terms is a word of text
+func containsTerms(text string, terms map[string]bool) bool {
+	words := searchTerms(text)
+	for term := range terms {
+		if !words[term] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MemoryStore) GetConversations(uid int, limit int, offset int) ([]Conversation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// latest message per counterpart, messages ids grow with time
+	var conversations []Conversation
+	seen := make(map[int]bool)
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := &m.messages[i]
+		if msg.GroupID > 0 || (msg.SenderID != uid && msg.RecipientID != uid) {
+			continue
+		}
+		counterpart := msg.SenderID
+		if msg.SenderID == uid {
+			counterpart = msg.RecipientID
+		}
+		other := m.user(counterpart)
+		if seen[counterpart] || other == nil {
+			continue
+		}
+		seen[counterpart] = true
+		conversation := Conversation{UserID: counterpart, Username: other.Username, LastMessage: *msg}
+		for j := range m.messages {
+			unread := &m.messages[j]
+			if unread.SenderID == counterpart && unread.RecipientID == uid && !unread.ReadAt.Valid {
+				conversation.Unread++
+			}
+		}
+		conversations = append(conversations, conversation)
+	}
+
+	if offset >= len(conversations) {
+		return []Conversation{}, nil
+	}
+	conversations = conversations[offset:]
+	if len(conversations) > limit {
+		conversations = conversations[:limit]
+	}
+	return conversations, nil
+}
+
+func (m *MemoryStore) GetConversationMessages(uid int, other_id int, before int, after int, limit int) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	exchanged := func(msg *Message) bool {
+		return ((msg.SenderID == uid && msg.RecipientID == other_id) || (msg.SenderID == other_id && msg.RecipientID == uid)) &&
+			(before <= 0 || msg.MsgID < before) && (after <= 0 || msg.MsgID > after)
+	}
+	if after > 0 {
+		return m.messagesFrom(after+1, limit, exchanged), nil
+	}
+
+	// the latest page, returned oldest first
+	msgs := []Message{}
+	for i := len(m.messages) - 1; i >= 0 && len(msgs) < limit; i-- {
+		if exchanged(&m.messages[i]) {
+			msgs = append(msgs, m.messages[i])
+		}
+	}
+	reverseMessages(msgs)
+	return msgs, nil
+}
+
+func (m *MemoryStore) CreateGroup(owner_id int, name string, member_ids []int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	uids := append([]int{owner_id}, member_ids...)
+	for _, uid := range uids {
+		if m.user(uid) == nil {
+			log.Println(errUserDoesNotExist.Error())
+			return 0, errUserDoesNotExist
+		}
+	}
+
+	group := Group{GroupID: len(m.groups) + 1, Name: name, OwnerID: owner_id, CreatedOn: memoryTimestamp()}
+	m.groups = append(m.groups, group)
+	for _, uid := range uids {
+		m.addGroupMember(group.GroupID, uid)
+	}
+	return group.GroupID, nil
+}
+
+func (m *MemoryStore) GetGroup(group_id int) (Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if group_id <= 0 || group_id > len(m.groups) {
+		return Group{}, errGroupDoesNotExist
+	}
+	return m.groups[group_id-1], nil
+}
+
+func (m *MemoryStore) IsGroupMember(group_id int, uid int) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.isGroupMember(group_id, uid), nil
+}
+
+// the lock must be held
+func (m *MemoryStore) isGroupMember(group_id int, uid int) bool {
+	for _, member := range m.members[group_id] {
+		if member.UserID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) AddGroupMember(group_id int, uid int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.user(uid) == nil {
+		log.Println(errUserDoesNotExist.Error())
+		return errUserDoesNotExist
+	}
+	m.addGroupMember(group_id, uid)
+	return nil
+}
+
+// add existing user uid to group_id unless already a member. The lock must be held
+func (m *MemoryStore) addGroupMember(group_id int, uid int) {
+	if m.isGroupMember(group_id, uid) {
+		return
+	}
+	m.members[group_id] = append(m.members[group_id], GroupMember{UserID: uid, JoinedOn: memoryTimestamp()})
+}
+
+func (m *MemoryStore) RemoveGroupMember(group_id int, uid int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if group_id > 0 && group_id <= len(m.groups) && m.groups[group_id-1].OwnerID == uid {
+		log.Println(errOwnerCannotLeave.Error())
+		return errOwnerCannotLeave
+	}
+	members := []GroupMember{}
+	for _, member := range m.members[group_id] {
+		if member.UserID != uid {
+			members = append(members, member)
+		}
+	}
+	m.members[group_id] = members
+	return nil
+}
+
+func (m *MemoryStore) GetGroupMembers(group_id int) ([]GroupMember, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	members := []GroupMember{}
+	for _, member := range m.members[group_id] {
+		member.Username = m.user(member.UserID).Username
+		members = append(members, member)
+	}
+	// in the order they joined like the databases, joined_on has a precision of a second
+	sort.SliceStable(members, func(i, j int) bool {
+		if members[i].JoinedOn != members[j].JoinedOn {
+			return members[i].JoinedOn < members[j].JoinedOn
+		}
+		return members[i].UserID < members[j].UserID
+	})
+	return members, nil
+}
+
+func (m *MemoryStore) GetGroupMessages(group_id int, msg_id int, limit int) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.messagesFrom(msg_id, limit, func(msg *Message) bool {
+		return msg.GroupID == group_id
+	}), nil
+}
+
+func (m *MemoryStore) AddReaction(msg_id int, uid int, emoji string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, reaction := range m.reactions {
+		if reaction.msgID == msg_id && reaction.uid == uid && reaction.emoji == emoji {
+			return nil
+		}
+	}
+	m.reactions = append(m.reactions, memoryReaction{msgID: msg_id, uid: uid, emoji: emoji, createdOn: memoryTimestamp()})
+	return nil
+}
+
+func (m *MemoryStore) RemoveReaction(msg_id int, uid int, emoji string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, reaction := range m.reactions {
+		if reaction.msgID == msg_id && reaction.uid == uid && reaction.emoji == emoji {
+			m.reactions = append(m.reactions[:i], m.reactions[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetReactions(viewer_id int, msg_ids []int) (map[int][]Reaction, error) {
+	wanted := make(map[int]bool)
+	for _, id := range msg_ids {
+		wanted[id] = true
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	type key struct {
+		msgID int
+		emoji string
+	}
+	counts := make(map[key]*Reaction)
+	firstOn := make(map[key]string)
+	var keys []key
+	for _, reaction := range m.reactions {
+		if !wanted[reaction.msgID] {
+			continue
+		}
+		k := key{reaction.msgID, reaction.emoji}
+		if counts[k] == nil {
+			counts[k] = &Reaction{MsgID: reaction.msgID, Emoji: reaction.emoji}
+			firstOn[k] = reaction.createdOn
+			keys = append(keys, k)
+		}
+		counts[k].Count++
+		counts[k].ReactedByMe = counts[k].ReactedByMe || reaction.uid == viewer_id
+		if reaction.createdOn < firstOn[k] {
+			firstOn[k] = reaction.createdOn
+		}
+	}
+
+	// the most used first, then the first used, like the databases
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := counts[keys[i]], counts[keys[j]]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if firstOn[keys[i]] != firstOn[keys[j]] {
+			return firstOn[keys[i]] < firstOn[keys[j]]
+		}
+		return a.Emoji < b.Emoji
+	})
+	reactions := make(map[int][]Reaction)
+	for _, k := range keys {
+		reactions[k.msgID] = append(reactions[k.msgID], *counts[k])
+	}
+	return reactions, nil
+}
+
+func (m *MemoryStore) CreateUpload(upload Upload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.uploads[upload.UploadID]; found {
+		log.Println("error inserting upload", errorUploadExists.Error())
+		return errorUploadExists
+	}
+	upload.CreatedOn = memoryTimestamp()
+	m.uploads[upload.UploadID] = upload
+	return nil
+}
+
+func (m *MemoryStore) GetUpload(upload_id string) (Upload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	upload, found := m.uploads[upload_id]
+	if !found {
+		return upload, errorUploadDoesNotExist
+	}
+	return upload, nil
+}
+
+func (m *MemoryStore) CanViewUpload(upload_id string, uid int) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if upload, found := m.uploads[upload_id]; found && upload.UserID == uid {
+		return true, nil
+	}
+	// deleted messages have no content, so no upload
+	for i := range m.messages {
+		msg := &m.messages[i]
+		if upload_id != "" && uploadOf(msg.Content) == upload_id && m.canRead(msg, uid) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// id of the upload an image or file was sent from, empty for other contents
+func uploadOf(c content.Content) string {
+	switch p := c.Payload.(type) {
+	case content.Image:
+		return p.UploadID
+	case content.File:
+		return p.UploadID
+	}
+	return ""
+}
+
+func (m *MemoryStore) AddThumbnail(thumbnail Thumbnail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sizes := m.thumbnails[thumbnail.MsgID]
+	if sizes == nil {
+		sizes = make(map[int]Thumbnail)
+		m.thumbnails[thumbnail.MsgID] = sizes
+	}
+	if _, found := sizes[thumbnail.Size]; !found {
+		sizes[thumbnail.Size] = thumbnail
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetThumbnails(msg_ids []int) (map[int][]Thumbnail, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	thumbnails := make(map[int][]Thumbnail)
+	for _, id := range msg_ids {
+		if len(m.thumbnails[id]) == 0 {
+			continue
+		}
+		sizes := []Thumbnail{}
+		for _, thumbnail := range m.thumbnails[id] {
+			sizes = append(sizes, thumbnail)
+		}
+		sort.Slice(sizes, func(i, j int) bool {
+			return sizes[i].Size < sizes[j].Size
+		})
+		thumbnails[id] = sizes
+	}
+	return thumbnails, nil
+}
+
+func (m *MemoryStore) SetLinkPreview(preview LinkPreview) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.previews[preview.MsgID] = preview
+	return nil
+}
+
+func (m *MemoryStore) GetLinkPreviews(msg_ids []int) (map[int]LinkPreview, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	previews := make(map[int]LinkPreview)
+	for _, id := range msg_ids {
+		if preview, found := m.previews[id]; found {
+			previews[id] = preview
+		}
+	}
+	return previews, nil
+}
@@ -3,7 +3,9 @@ package models
 import (
 	"database/sql"
 	"errors"
+	"github.com/dtsang7/ASAPP/content"
 	"log"
+	"strings"
 )
 
 type GetMessage struct {
@@ -11,34 +13,84 @@ type GetMessage struct {
 	SenderID    int    `json:"sender_id"`
 	RecipientID int    `json:"recipient_id"`
 	Type        string `json:"type"`
-	Message     string `json:"message, omitempty"`
-	Width       int    `json:"width, omitempty"`
-	Height      int    `json:"height, omitempty"`
-	Source      string `json:"source, omitempty"`
-	Url         string `json:"url, omitempty"`
+	Message     string `json:"message,omitempty"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Url         string `json:"url,omitempty"`
 	TimeStamp   string `json:"created_on"`
 }
 
 type Message struct {
-	MsgID       int            `db:"msg_id" json:"msg_id"`
-	SenderID    int            `db:"sender_id" json:"sender_id"`
-	RecipientID int            `db:"recipient_id" json:"recipient_id"`
-	Type        string         `db:"type"`
-	Message     sql.NullString `db:"msg"`
-	Width       sql.NullInt64  `db:"width"`
-	Height      sql.NullInt64  `db:"height"`
-	Url         sql.NullString `db:"i_url, v_url"`
-	Source      sql.NullString `db:"source"`
-	TimeStamp   string         `db:"created_on`
+	MsgID       int             `db:"msg_id" json:"msg_id"`
+	SenderID    int             `db:"sender_id" json:"sender_id"`
+	RecipientID int             `db:"recipient_id" json:"recipient_id"`
+	GroupID     int             `db:"group_id" json:"group_id"`
+	ReplyTo     int             `db:"reply_to" json:"reply_to"`
+	Type        string          `db:"type"`
+	Content     content.Content `db:"-"`
+	TimeStamp   string          `db:"created_on"`
+	DeliveredAt sql.NullString  `db:"delivered_at"`
+	ReadAt      sql.NullString  `db:"read_at"`
+	EditedAt    sql.NullString  `db:"edited_at"`
+	DeletedAt   sql.NullString  `db:"deleted_at"`
 }
 
 const (
 	ErrorCreatingMessage         = "Error creating message"
 	ErrorMessageTypeNotSupported = "Message type not supported"
+	ErrorMessageDoesNotExist     = "Message does not exist"
 )
 
 var errorCreateMessage = errors.New(ErrorCreatingMessage)
 var errorMessageTypeNotSupported = errors.New(ErrorMessageTypeNotSupported)
+var errorMessageDoesNotExist = errors.New(ErrorMessageDoesNotExist)
+
+// Columns selecting a message, rows are read with scanMessage and their content with loadContent
+const messageColumns = `messages.msg_id, sender_id, COALESCE(recipient_id, 0), COALESCE(group_id, 0), COALESCE(reply_to, 0), type, created_on, delivered_at, read_at, edited_at, deleted_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scan messageColumns into msg, followed by any extra columns of the query
+func scanMessage(row scanner, msg *Message, extra ...interface{}) error {
+	dest := []interface{}{&msg.MsgID, &msg.SenderID, &msg.RecipientID, &msg.GroupID, &msg.ReplyTo, &msg.Type, content.RequiredTimestamp(&msg.TimeStamp),
+		content.Timestamp(&msg.DeliveredAt), content.Timestamp(&msg.ReadAt), content.Timestamp(&msg.EditedAt), content.Timestamp(&msg.DeletedAt)}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// load the content of msgs from the table of their type, deleted messages have no content
+func loadContent(q content.Querier, msgs []Message) error {
+	idsByType := make(map[string][]int)
+	for _, msg := range msgs {
+		idsByType[msg.Type] = append(idsByType[msg.Type], msg.MsgID)
+	}
+	payloads := make(map[int]content.Payload)
+	for name, ids := range idsByType {
+		t, found := content.Lookup(name)
+		if !found {
+			log.Println(errorMessageTypeNotSupported.Error(), name)
+			continue
+		}
+		err := inChunks(ids, func(chunk []int) error {
+			loaded, err := t.Load(q, chunk)
+			for id, p := range loaded {
+				payloads[id] = p
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for i := range msgs {
+		if p, found := payloads[msgs[i].MsgID]; found {
+			msgs[i].Content = content.Content{Type: msgs[i].Type, Payload: p}
+		}
+	}
+	return nil
+}
 
 func (dao *DAO) SendMessage(msg Message) (int, string, error) {
 	var timeStamp string
@@ -50,90 +102,164 @@ func (dao *DAO) SendMessage(msg Message) (int, string, error) {
 		return 0, timeStamp, err
 	}
 
-	//store message info
-	query := "INSERT INTO messages (sender_id, recipient_id, type) VALUES (?, ?, ?)"
-	res, err := tx.Exec(query, msg.SenderID, msg.RecipientID, mtype)
+	//store message info, a message has either a recipient or a group
+	query := "INSERT INTO messages (sender_id, recipient_id, group_id, reply_to, type) VALUES (?, ?, ?, ?, ?)"
+	msgID, err := tx.insert(query, "msg_id", msg.SenderID, nullID(msg.RecipientID), nullID(msg.GroupID), nullID(msg.ReplyTo), mtype)
 	if err != nil {
 		tx.Rollback()
 		log.Println("error inserting message into messages table", err.Error())
 		return 0, timeStamp, errorCreateMessage
 	}
-	//retrieve message id
-	msgID, err := res.LastInsertId()
+	//retrieve timestamp
+	query = "SELECT created_on FROM messages WHERE msg_id = ?"
+	err = tx.QueryRow(query, msgID).Scan(content.RequiredTimestamp(&timeStamp))
 	if err != nil {
 		tx.Rollback()
-		log.Println("error retrieving last inserted message id", err.Error())
+		log.Println("error retrieving timestamp", err.Error())
 		return 0, timeStamp, err
 	}
-	//retrieve timestamp
-	query = "SELECT created_on FROM messages WHERE msg_id = ?"
-	err = tx.QueryRow(query, msgID).Scan(&timeStamp)
+
+	// store content in the table of its type
+	t, found := content.Lookup(mtype)
+	if !found {
+		tx.Rollback()
+		log.Println(errorMessageTypeNotSupported.Error())
+		return 0, timeStamp, errorMessageTypeNotSupported
+	}
+	err = t.Insert(tx, msgID, msg.Content.Payload)
 	if err != nil {
 		tx.Rollback()
-		log.Println("error retrieving timestamp", err.Error())
 		return 0, timeStamp, err
 	}
 
-	// store messages based on type
-	switch mtype {
-	case "text":
-		query := "INSERT INTO texts (msg_id, msg) VALUES (?, ?)"
+	tx.Commit()
+	return msgID, timeStamp, nil
+}
 
-		_, err = tx.Exec(query, msgID, msg.Message)
-		if err != nil {
-			tx.Rollback()
-			log.Println("error inserting message into texts table", err.Error())
-			return 0, timeStamp, err
-		}
-	case "image":
-		query := "INSERT INTO images (msg_id, width, height, i_url) VALUES (?, ?, ?, ?)"
+// get messages sent to recipient_id that viewer_id sent or received
+func (dao *DAO) GetMessages(viewer_id int, recipient_id int, msg_id int, limit int) ([]Message, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		log.Println("error starting Tx", err.Error())
+		return nil, err
+	}
 
-		_, err = tx.Exec(query, msgID, msg.Width, msg.Height, msg.Url)
+	// Retrieve messages, then their content
+	query := `SELECT ` + messageColumns + `
+			  FROM messages
+			  WHERE recipient_id = ? AND (recipient_id = ? OR sender_id = ?) AND messages.msg_id >= ?
+			  ORDER BY messages.msg_id
+			  LIMIT ?`
+
+	res, err := tx.Query(query, recipient_id, viewer_id, viewer_id, msg_id, limit)
+	if err != nil {
+		tx.Rollback()
+		log.Println("error retrieving messages", err.Error())
+		return nil, err
+	}
+	defer res.Close()
+
+	msgs := []Message{}
+	for res.Next() {
+		var msg Message
+		err := scanMessage(res, &msg)
 		if err != nil {
 			tx.Rollback()
-			log.Println("error inserting image into images table", err.Error())
-			return 0, timeStamp, err
+			log.Println("error scanning messages", err.Error())
+			return nil, err
 		}
-	case "video":
-		query := "INSERT INTO videos (msg_id, source, v_url) VALUES (?, ?, ?)"
+		msgs = append(msgs, msg)
+	}
+	err = res.Err()
+	if err != nil {
+		tx.Rollback()
+		log.Println("error occured during iteration", err.Error())
+		return nil, err
+	}
+	err = loadContent(tx, msgs)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	tx.Commit()
+	return msgs, nil
+}
+
+// get the latest messages sent to recipient_id that viewer_id sent or received, older than
+// before when it is set. Up to limit messages are returned, oldest first
+func (dao *DAO) GetMessagesBefore(viewer_id int, recipient_id int, before int, limit int) ([]Message, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		log.Println("error starting Tx", err.Error())
+		return nil, err
+	}
+
+	query := `SELECT ` + messageColumns + `
+			  FROM messages
+			  WHERE recipient_id = ? AND (recipient_id = ? OR sender_id = ?)`
+	args := []interface{}{recipient_id, viewer_id, viewer_id}
+	if before > 0 {
+		query += " AND messages.msg_id < ?"
+		args = append(args, before)
+	}
+	query += " ORDER BY messages.msg_id DESC LIMIT ?"
+	args = append(args, limit)
 
-		_, err = tx.Exec(query, msgID, msg.Source, msg.Url)
+	res, err := tx.Query(query, args...)
+	if err != nil {
+		tx.Rollback()
+		log.Println("error retrieving messages", err.Error())
+		return nil, err
+	}
+	defer res.Close()
+
+	msgs := []Message{}
+	for res.Next() {
+		var msg Message
+		err := scanMessage(res, &msg)
 		if err != nil {
 			tx.Rollback()
-			log.Println("error inserting video into videos table", err.Error())
-			return 0, timeStamp, err
+			log.Println("error scanning messages", err.Error())
+			return nil, err
 		}
-	default:
+		msgs = append(msgs, msg)
+	}
+	err = res.Err()
+	if err != nil {
 		tx.Rollback()
-		log.Println(errorMessageTypeNotSupported.Error())
-		return 0, timeStamp, errorMessageTypeNotSupported
+		log.Println("error occured during iteration", err.Error())
+		return nil, err
+	}
+	err = loadContent(tx, msgs)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
 	}
-
 	tx.Commit()
-	return int(msgID), timeStamp, nil
+	reverseMessages(msgs)
+	return msgs, nil
 }
 
-func (dao *DAO) GetMessages(recipient_id int, msg_id int, limit int) ([]Message, error) {
+// get the messages uid received from msg_id on, sent to uid or by others to the groups uid is
+// a member of. Up to limit messages are returned, oldest first
+func (dao *DAO) GetReceivedMessages(uid int, msg_id int, limit int) ([]Message, error) {
 	tx, err := dao.db.Begin()
 	if err != nil {
 		log.Println("error starting Tx", err.Error())
 		return nil, err
 	}
 
-	// Retrieve messages of three types(text, image, video)
-	query := `SELECT messages.msg_id, sender_id, recipient_id, type, msg, width, height, i_url, v_url, source, created_on
+	query := `SELECT ` + messageColumns + `
 			  FROM messages
-			  LEFT JOIN texts ON messages.msg_id = texts.msg_id
-			  LEFT JOIN images ON messages.msg_id = images.msg_id
-			  LEFT JOIN videos ON messages.msg_id = videos.msg_id
-			  WHERE recipient_id = ? AND messages.msg_id >= ?
+			  WHERE messages.msg_id >= ? AND (recipient_id = ? OR (sender_id <> ? AND group_id IN
+				  (SELECT group_id FROM group_members WHERE uid = ?)))
 			  ORDER BY messages.msg_id
 			  LIMIT ?`
 
-	res, err := tx.Query(query, recipient_id, msg_id, limit)
+	res, err := tx.Query(query, msg_id, uid, uid, uid, limit)
 	if err != nil {
 		tx.Rollback()
-		log.Println("error retrieving messages", err.Error())
+		log.Println("error retrieving received messages", err.Error())
 		return nil, err
 	}
 	defer res.Close()
@@ -141,21 +267,12 @@ func (dao *DAO) GetMessages(recipient_id int, msg_id int, limit int) ([]Message,
 	msgs := []Message{}
 	for res.Next() {
 		var msg Message
-		// Retrieving both image and video url, Message url is set later based on message type
-		var imageUrl sql.NullString
-		var videoUrl sql.NullString
-		err := res.Scan(&msg.MsgID, &msg.SenderID, &msg.RecipientID, &msg.Type, &msg.Message, &msg.Width, &msg.Height, &imageUrl, &videoUrl, &msg.Source, &msg.TimeStamp)
+		err := scanMessage(res, &msg)
 		if err != nil {
 			tx.Rollback()
 			log.Println("error scanning messages", err.Error())
 			return nil, err
 		}
-		if imageUrl.Valid {
-			msg.Url = imageUrl
-		} else if videoUrl.Valid {
-			msg.Url = videoUrl
-		}
-
 		msgs = append(msgs, msg)
 	}
 	err = res.Err()
@@ -164,6 +281,226 @@ func (dao *DAO) GetMessages(recipient_id int, msg_id int, limit int) ([]Message,
 		log.Println("error occured during iteration", err.Error())
 		return nil, err
 	}
+	err = loadContent(tx, msgs)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
 	tx.Commit()
 	return msgs, nil
 }
+
+// mark messages received by recipient_id as delivered, already delivered messages keep their time
+func (dao *DAO) MarkDelivered(recipient_id int, msg_ids []int) error {
+	return inChunks(msg_ids, func(ids []int) error {
+		query := "UPDATE messages SET delivered_at = CURRENT_TIMESTAMP WHERE recipient_id = ? AND delivered_at IS NULL AND msg_id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"
+		args := []interface{}{recipient_id}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		_, err := dao.db.Exec(query, args...)
+		if err != nil {
+			log.Println("error marking messages delivered", err.Error())
+		}
+		return err
+	})
+}
+
+// mark every message received by recipient_id up to msg_id as read, limited to
+// messages from sender_id when it is set. Returns the number of messages marked
+func (dao *DAO) MarkRead(recipient_id int, msg_id int, sender_id int) (int, error) {
+	query := `UPDATE messages SET read_at = CURRENT_TIMESTAMP, delivered_at = COALESCE(delivered_at, CURRENT_TIMESTAMP)
+			  WHERE recipient_id = ? AND msg_id <= ? AND read_at IS NULL`
+	args := []interface{}{recipient_id, msg_id}
+	if sender_id > 0 {
+		query += " AND sender_id = ?"
+		args = append(args, sender_id)
+	}
+	res, err := dao.db.Exec(query, args...)
+	if err != nil {
+		log.Println("error marking messages read", err.Error())
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println("error retrieving marked messages count", err.Error())
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// get a single message by id, deleted messages are returned as tombstones
+func (dao *DAO) GetMessage(msg_id int) (Message, error) {
+	var msg Message
+	query := `SELECT ` + messageColumns + `
+			  FROM messages
+			  WHERE messages.msg_id = ?`
+	err := scanMessage(dao.db.QueryRow(query, msg_id), &msg)
+	if err == sql.ErrNoRows {
+		return msg, errorMessageDoesNotExist
+	}
+	if err != nil {
+		log.Println("error retrieving message", err.Error())
+		return msg, err
+	}
+	msgs := []Message{msg}
+	err = loadContent(dao.db, msgs)
+	return msgs[0], err
+}
+
+// get the messages with msg_ids by id, ids that don't exist are left out
+func (dao *DAO) GetMessagesByID(msg_ids []int) (map[int]Message, error) {
+	msgs := make(map[int]Message)
+	var found []Message
+	err := inChunks(msg_ids, func(ids []int) error {
+		query := `SELECT ` + messageColumns + `
+				  FROM messages
+				  WHERE messages.msg_id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		var args []interface{}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		res, err := dao.db.Query(query, args...)
+		if err != nil {
+			log.Println("error retrieving messages", err.Error())
+			return err
+		}
+		defer res.Close()
+
+		for res.Next() {
+			var msg Message
+			err := scanMessage(res, &msg)
+			if err != nil {
+				log.Println("error scanning messages", err.Error())
+				return err
+			}
+			found = append(found, msg)
+		}
+		err = res.Err()
+		if err != nil {
+			log.Println("error occured during iteration", err.Error())
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = loadContent(dao.db, found)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range found {
+		msgs[msg.MsgID] = msg
+	}
+	return msgs, nil
+}
+
+// replace the content of a message, of a type that can be updated, and record when it was edited
+func (dao *DAO) EditMessage(msg_id int, c content.Content) error {
+	t, found := content.Lookup(c.Type)
+	updater, ok := t.(content.Updater)
+	if !found || !ok {
+		log.Println(errorMessageTypeNotSupported.Error())
+		return errorMessageTypeNotSupported
+	}
+
+	tx, err := dao.db.Begin()
+	if err != nil {
+		log.Println("error starting Tx", err.Error())
+		return err
+	}
+
+	err = updater.Update(tx, msg_id, c.Payload)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	query := "UPDATE messages SET edited_at = CURRENT_TIMESTAMP WHERE msg_id = ?"
+	_, err = tx.Exec(query, msg_id)
+	if err != nil {
+		tx.Rollback()
+		log.Println("error updating message", err.Error())
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// remove the content of a message and keep the message row as a tombstone
+func (dao *DAO) DeleteMessage(msg_id int) error {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		log.Println("error starting Tx", err.Error())
+		return err
+	}
+
+	var mtype string
+	query := "SELECT type FROM messages WHERE msg_id = ?"
+	err = tx.QueryRow(query, msg_id).Scan(&mtype)
+	if err != nil {
+		tx.Rollback()
+		log.Println("error retrieving message type", err.Error())
+		return err
+	}
+	if t, found := content.Lookup(mtype); found {
+		err = t.Delete(tx, msg_id)
+		if err != nil {
+			tx.Rollback()
+			log.Println("error deleting message content", err.Error())
+			return err
+		}
+	}
+	for _, table := range []string{"reactions", "thumbnails", "link_previews"} {
+		query := "DELETE FROM " + table + " WHERE msg_id = ?"
+		_, err = tx.Exec(query, msg_id)
+		if err != nil {
+			tx.Rollback()
+			log.Println("error deleting message", table, err.Error())
+			return err
+		}
+	}
+
+	query = "UPDATE messages SET deleted_at = CURRENT_TIMESTAMP WHERE msg_id = ?"
+	_, err = tx.Exec(query, msg_id)
+	if err != nil {
+		tx.Rollback()
+		log.Println("error marking message deleted", err.Error())
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// most ids in one IN (...) list, SQLite allows at most 999 variables in a statement
+const maxInList = 500
+
+// call f with ids split in lists of at most maxInList ids, until f fails
+func inChunks(ids []int, f func(chunk []int) error) error {
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > maxInList {
+			n = maxInList
+		}
+		err := f(ids[:n])
+		if err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+// reverse msgs in place, pages read backwards are returned oldest first
+func reverseMessages(msgs []Message) {
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+}
+
+// store ids of optional references as NULL when unset
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id > 0}
+}
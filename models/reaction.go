@@ -0,0 +1,78 @@
+package models
+
+import (
+	"log"
+	"strings"
+)
+
+// Reaction is the number of users who reacted to a message with an emoji
+type Reaction struct {
+	MsgID       int
+	Emoji       string
+	Count       int
+	ReactedByMe bool
+}
+
+// add reaction of uid to msg_id, reacting twice with the same emoji does nothing
+func (dao *DAO) AddReaction(msg_id int, uid int, emoji string) error {
+	query := "INSERT INTO reactions (msg_id, uid, emoji) VALUES (?, ?, ?) ON CONFLICT DO NOTHING"
+	_, err := dao.db.Exec(query, msg_id, uid, emoji)
+	if err != nil {
+		log.Println("error inserting reaction", err.Error())
+		return err
+	}
+	return nil
+}
+
+// remove reaction of uid from msg_id
+func (dao *DAO) RemoveReaction(msg_id int, uid int, emoji string) error {
+	query := "DELETE FROM reactions WHERE msg_id = ? AND uid = ? AND emoji = ?"
+	_, err := dao.db.Exec(query, msg_id, uid, emoji)
+	if err != nil {
+		log.Println("error removing reaction", err.Error())
+		return err
+	}
+	return nil
+}
+
+// get reactions on msg_ids by message, the most used first.
+// ReactedByMe is set when viewer_id is one of the users who reacted
+func (dao *DAO) GetReactions(viewer_id int, msg_ids []int) (map[int][]Reaction, error) {
+	reactions := make(map[int][]Reaction)
+	err := inChunks(msg_ids, func(ids []int) error {
+		query := `SELECT msg_id, emoji, COUNT(*), MAX(CASE WHEN uid = ? THEN 1 ELSE 0 END)
+				  FROM reactions
+				  WHERE msg_id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)
+				  GROUP BY msg_id, emoji
+				  ORDER BY msg_id, COUNT(*) DESC, MIN(created_on), emoji`
+		args := []interface{}{viewer_id}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		res, err := dao.db.Query(query, args...)
+		if err != nil {
+			log.Println("error retrieving reactions", err.Error())
+			return err
+		}
+		defer res.Close()
+
+		for res.Next() {
+			var reaction Reaction
+			err := res.Scan(&reaction.MsgID, &reaction.Emoji, &reaction.Count, &reaction.ReactedByMe)
+			if err != nil {
+				log.Println("error scanning reactions", err.Error())
+				return err
+			}
+			reactions[reaction.MsgID] = append(reactions[reaction.MsgID], reaction)
+		}
+		err = res.Err()
+		if err != nil {
+			log.Println("error occured during iteration", err.Error())
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reactions, nil
+}
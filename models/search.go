@@ -0,0 +1,177 @@
+package models
+
+import (
+	"github.com/dtsang7/ASAPP/content"
+	"html"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// words around the first match kept in a snippet
+const snippetWords = 16
+
+// SearchResult is a text message matching a search with the matching part highlighted
+type SearchResult struct {
+	Message Message
+	Snippet string
+}
+
+// get the text messages uid sent, received or can read in a group that match query,
+// newest first. Every word of query has to match, search operators are not interpreted
+func (dao *DAO) SearchMessages(uid int, query string, limit int, offset int) ([]SearchResult, error) {
+	if dao.db.dialect.name == "postgres" {
+		return dao.searchPostgres(uid, query, limit, offset)
+	}
+	return dao.searchSQLite(uid, query, limit, offset)
+}
+
+// search with the FTS5 index of texts
+func (dao *DAO) searchSQLite(uid int, query string, limit int, offset int) ([]SearchResult, error) {
+	sqlQuery := `SELECT ` + messageColumns + `
+			  FROM (SELECT rowid AS msg_id FROM texts_fts WHERE texts_fts MATCH ?) AS matches
+			  JOIN messages ON messages.msg_id = matches.msg_id
+			  WHERE messages.sender_id = ? OR messages.recipient_id = ?
+			  	OR messages.group_id IN (SELECT group_id FROM group_members WHERE uid = ?)
+			  ORDER BY messages.msg_id DESC
+			  LIMIT ? OFFSET ?`
+	return dao.searchResults(sqlQuery, query, ftsQuery(query), uid, uid, uid, limit, offset)
+}
+
+// search with the GIN index of texts
+func (dao *DAO) searchPostgres(uid int, query string, limit int, offset int) ([]SearchResult, error) {
+	sqlQuery := `SELECT ` + messageColumns + `
+			  FROM texts
+			  JOIN messages ON messages.msg_id = texts.msg_id
+			  WHERE to_tsvector('simple', texts.msg) @@ plainto_tsquery('simple', ?)
+			  	AND (messages.sender_id = ? OR messages.recipient_id = ?
+			  	OR messages.group_id IN (SELECT group_id FROM group_members WHERE uid = ?))
+			  ORDER BY messages.msg_id DESC
+			  LIMIT ? OFFSET ?`
+	return dao.searchResults(sqlQuery, query, query, uid, uid, uid, limit, offset)
+}
+
+// run a search query selecting messageColumns, the snippets are cut from the texts around
+// the words of query
+func (dao *DAO) searchResults(sqlQuery string, query string, args ...interface{}) ([]SearchResult, error) {
+	res, err := dao.db.Query(sqlQuery, args...)
+	if err != nil {
+		log.Println("error searching messages", err.Error())
+		return nil, err
+	}
+	defer res.Close()
+
+	msgs := []Message{}
+	for res.Next() {
+		var msg Message
+		err := scanMessage(res, &msg)
+		if err != nil {
+			log.Println("error scanning search results", err.Error())
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	err = res.Err()
+	if err != nil {
+		log.Println("error occured during iteration", err.Error())
+		return nil, err
+	}
+	err = loadContent(dao.db, msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := searchTerms(query)
+	results := []SearchResult{}
+	for _, msg := range msgs {
+		text, _ := msg.Content.Payload.(content.Text)
+		results = append(results, SearchResult{msg, snippet(text.Text, terms)})
+	}
+	return results, nil
+}
+
+// quote every word of query as an FTS5 string so user input can't be a syntax error
+func ftsQuery(query string) string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		terms = append(terms, `"`+strings.Replace(word, `"`, `""`, -1)+`"`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// lowercased words of text, letters and digits separated by anything else
+func searchTerms(text string) map[string]bool {
+	terms := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(text, isSeparator) {
+		terms[strings.ToLower(word)] = true
+	}
+	return terms
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// HTML escaped part of text, up to snippetWords words starting a few words before the first
+// word in terms, with the words in terms wrapped in <mark></mark>. Cut ends are marked with …
+func snippet(text string, terms map[string]bool) string {
+	// byte offsets of the words of text
+	var starts, ends []int
+	start := -1
+	for i, r := range text {
+		if !isSeparator(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			starts, ends = append(starts, start), append(ends, i)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		starts, ends = append(starts, start), append(ends, len(text))
+	}
+
+	first := 0
+	for i := range starts {
+		if terms[strings.ToLower(text[starts[i]:ends[i]])] {
+			first = i
+			break
+		}
+	}
+	from := first - snippetWords/4
+	if from > len(starts)-snippetWords {
+		from = len(starts) - snippetWords
+	}
+	if from < 0 {
+		from = 0
+	}
+	to := from + snippetWords
+	if to > len(starts) {
+		to = len(starts)
+	}
+
+	var s strings.Builder
+	pos := 0
+	if from > 0 {
+		s.WriteString("…")
+		pos = starts[from]
+	}
+	for i := from; i < to; i++ {
+		s.WriteString(html.EscapeString(text[pos:starts[i]]))
+		word := html.EscapeString(text[starts[i]:ends[i]])
+		if terms[strings.ToLower(text[starts[i]:ends[i]])] {
+			word = "<mark>" + word + "</mark>"
+		}
+		s.WriteString(word)
+		pos = ends[i]
+	}
+	if to < len(starts) {
+		s.WriteString("…")
+	} else {
+		s.WriteString(html.EscapeString(text[pos:]))
+	}
+	return s.String()
+}
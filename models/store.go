@@ -0,0 +1,59 @@
+package models
+
+import (
+	"github.com/dtsang7/ASAPP/content"
+	"time"
+)
+
+// Store is the storage of users, messages and everything attached to them. The DAO stores
+// in SQLite or PostgreSQL, the MemoryStore in memory for tests and local runs
+type Store interface {
+	CheckDB() (int, error)
+	CreateUser(usr User) (int, error)
+	LoginUser(existingUser User) (int, error)
+	SendMessage(msg Message) (int, string, error)
+	GetMessages(viewer_id int, recipient_id int, msg_id int, limit int) ([]Message, error)
+	GetMessagesBefore(viewer_id int, recipient_id int, before int, limit int) ([]Message, error)
+	GetReceivedMessages(uid int, msg_id int, limit int) ([]Message, error)
+
+	// tokens
+	RevokeToken(jti string, expiresOn time.Time) (bool, error)
+	IsTokenRevoked(jti string) (bool, error)
+
+	// messages
+	MarkDelivered(recipient_id int, msg_ids []int) error
+	MarkRead(recipient_id int, msg_id int, sender_id int) (int, error)
+	GetMessage(msg_id int) (Message, error)
+	GetMessagesByID(msg_ids []int) (map[int]Message, error)
+	EditMessage(msg_id int, c content.Content) error
+	DeleteMessage(msg_id int) error
+	SearchMessages(uid int, query string, limit int, offset int) ([]SearchResult, error)
+
+	// conversations and groups
+	GetConversations(uid int, limit int, offset int) ([]Conversation, error)
+	GetConversationMessages(uid int, other_id int, before int, after int, limit int) ([]Message, error)
+	CreateGroup(owner_id int, name string, member_ids []int) (int, error)
+	GetGroup(group_id int) (Group, error)
+	IsGroupMember(group_id int, uid int) (bool, error)
+	AddGroupMember(group_id int, uid int) error
+	RemoveGroupMember(group_id int, uid int) error
+	GetGroupMembers(group_id int) ([]GroupMember, error)
+	GetGroupMessages(group_id int, msg_id int, limit int) ([]Message, error)
+
+	// reactions
+	AddReaction(msg_id int, uid int, emoji string) error
+	RemoveReaction(msg_id int, uid int, emoji string) error
+	GetReactions(viewer_id int, msg_ids []int) (map[int][]Reaction, error)
+
+	// uploads, thumbnails and link previews
+	CreateUpload(upload Upload) error
+	GetUpload(upload_id string) (Upload, error)
+	CanViewUpload(upload_id string, uid int) (bool, error)
+	AddThumbnail(thumbnail Thumbnail) error
+	GetThumbnails(msg_ids []int) (map[int][]Thumbnail, error)
+	SetLinkPreview(preview LinkPreview) error
+	GetLinkPreviews(msg_ids []int) (map[int]LinkPreview, error)
+}
+
+var _ Store = (*DAO)(nil)
+var _ Store = (*MemoryStore)(nil)
@@ -0,0 +1,517 @@
+package models
+
+import (
+	"fmt"
+	"github.com/dtsang7/ASAPP/content"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a DAO on a new database with the migrations applied
+func migratedDAO(driverName string, dataSource string) *DAO {
+	dao := CreateDAO(driverName, dataSource)
+	dao.RunMigrations(filepath.Join("..", MigrationsDir))
+	return dao
+}
+
+/*
+Test Scenario:
+1. The in-memory store passes the conformance tests
+2. Concurrent users can send and read messages, every message gets its own id
+*/
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+	testLargePage(t, NewMemoryStore())
+
+	store := NewMemoryStore()
+	sender, _ := store.CreateUser(User{Username: "sender", Password: "test_password"})
+	recipient, _ := store.CreateUser(User{Username: "recipient", Password: "test_password"})
+	var wg sync.WaitGroup
+	ids := make(chan int, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			text := content.Content{Type: "text", Payload: content.Text{Text: fmt.Sprint("message ", i)}}
+			id, _, err := store.SendMessage(Message{SenderID: sender, RecipientID: recipient, Type: "text", Content: text})
+			if err != nil {
+				t.Error(err)
+			}
+			ids <- id
+			store.GetMessages(recipient, recipient, 1, 10)
+			store.MarkRead(recipient, id, sender)
+		}(i)
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[int]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("message id %d given twice", id)
+		}
+		seen[id] = true
+	}
+	msgs, err := store.GetMessages(recipient, recipient, 1, 1000)
+	if err != nil || len(msgs) != 100 {
+		t.Fatalf("expected 100 messages, got %d %v", len(msgs), err)
+	}
+}
+
+/*
+Test Scenario:
+1. The DAO on a new SQLite database passes the conformance tests
+*/
+func TestSQLiteStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dao := migratedDAO("sqlite3", filepath.Join(dir, "test.db"))
+	testStore(t, dao)
+	testLargePage(t, dao)
+}
+
+// pages of more messages than SQLite allows variables in a statement, the ids of a page
+// are used to load their content, reactions, thumbnails and previews
+func testLargePage(t *testing.T, store Store) {
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	owner, err := store.CreateUser(User{Username: "large" + suffix, Password: "test_password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupID, err := store.CreateGroup(owner, "large", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for i := 0; i < 1200; i++ {
+		text := content.Content{Type: "text", Payload: content.Text{Text: fmt.Sprint("message ", i)}}
+		id, _, err := store.SendMessage(Message{SenderID: owner, GroupID: groupID, Type: "text", Content: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	store.AddReaction(ids[1100], owner, "👍")
+
+	msgs, err := store.GetGroupMessages(groupID, 0, 5000)
+	if err != nil || len(msgs) != 1200 || msgs[1199].Content.Payload.(content.Text).Text != "message 1199" {
+		t.Fatalf("unexpected page of %d messages %v", len(msgs), err)
+	}
+	byID, err := store.GetMessagesByID(ids)
+	if err != nil || len(byID) != 1200 {
+		t.Fatalf("unexpected %d messages by id %v", len(byID), err)
+	}
+	reactions, err := store.GetReactions(owner, ids)
+	if err != nil || len(reactions[ids[1100]]) != 1 {
+		t.Fatalf("unexpected reactions %v %v", reactions, err)
+	}
+	if _, err := store.GetThumbnails(ids); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetLinkPreviews(ids); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MarkDelivered(owner, ids); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// conformance tests of a Store. The store may have data of other tests, so names are unique
+// and ids are only compared to the ones it returned
+func testStore(t *testing.T, store Store) {
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	const missing = 1 << 30
+	// the steps share the users and messages they create, once one fails the next ones are skipped
+	ok := true
+	step := func(name string, test func(t *testing.T)) {
+		ok = ok && t.Run(name, test)
+	}
+	send := func(t *testing.T, msg Message) int {
+		msg.Type = msg.Content.Type
+		id, timeStamp, err := store.SendMessage(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := time.Parse(time.RFC3339, timeStamp); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	var alice, bob, carol int
+	var first, reply, second, liveID, groupID, groupMsg int
+	image := content.Content{Type: "image", Payload: content.Image{Width: 2, Height: 1, Url: "https://example.com/cat.png"}}
+
+	step("health", func(t *testing.T) {
+		res, err := store.CheckDB()
+		if err != nil || res != 1 {
+			t.Fatalf("check returned %d %v", res, err)
+		}
+	})
+
+	step("users", func(t *testing.T) {
+		var users []int
+		for _, name := range []string{"alice", "bob", "carol"} {
+			id, err := store.CreateUser(User{Username: name + suffix, Password: "test_password"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id <= 0 || (len(users) > 0 && id == users[len(users)-1]) {
+				t.Fatalf("unexpected user id %d", id)
+			}
+			users = append(users, id)
+		}
+		alice, bob, carol = users[0], users[1], users[2]
+		if _, err := store.CreateUser(User{Username: "alice" + suffix, Password: "other_password"}); err != errUserExist {
+			t.Fatalf("expected %v, got %v", errUserExist, err)
+		}
+		if id, err := store.LoginUser(User{Username: "bob" + suffix, Password: "test_password"}); err != nil || id != bob {
+			t.Fatalf("login returned %d %v", id, err)
+		}
+		if _, err := store.LoginUser(User{Username: "bob" + suffix, Password: "wrong_password"}); err != errWrongPassword {
+			t.Fatalf("expected %v, got %v", errWrongPassword, err)
+		}
+		if _, err := store.LoginUser(User{Username: "nobody" + suffix, Password: "test_password"}); err != errUserDoesNotExist {
+			t.Fatalf("expected %v, got %v", errUserDoesNotExist, err)
+		}
+	})
+
+	step("messages", func(t *testing.T) {
+		hello := content.Content{Type: "text", Payload: content.Text{Text: "hello bob " + suffix}}
+		first = send(t, Message{SenderID: alice, RecipientID: bob, Content: hello})
+		reply = send(t, Message{SenderID: bob, RecipientID: alice, ReplyTo: first, Content: image})
+		second = send(t, Message{SenderID: alice, RecipientID: bob, Content: content.Content{Type: "text", Payload: content.Text{Text: "still there?"}}})
+		if !(first < reply && reply < second) {
+			t.Fatalf("message ids don't grow %d %d %d", first, reply, second)
+		}
+		if _, _, err := store.SendMessage(Message{SenderID: alice, RecipientID: bob, Type: "sticker"}); err != errorMessageTypeNotSupported {
+			t.Fatalf("expected %v, got %v", errorMessageTypeNotSupported, err)
+		}
+
+		msgs, err := store.GetMessages(bob, bob, first, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 2 || msgs[0].MsgID != first || msgs[1].MsgID != second || msgs[0].SenderID != alice || !reflect.DeepEqual(msgs[0].Content, hello) {
+			t.Fatalf("unexpected messages %v", msgs)
+		}
+		if msgs, _ := store.GetMessages(bob, bob, first, 1); len(msgs) != 1 || msgs[0].MsgID != first {
+			t.Fatalf("limit not applied %v", msgs)
+		}
+		if msgs, _ := store.GetMessages(carol, bob, first, 10); len(msgs) != 0 {
+			t.Fatalf("messages shown to a third user %v", msgs)
+		}
+		if msgs, err := store.GetMessagesBefore(bob, bob, 0, 1); err != nil || len(msgs) != 1 || msgs[0].MsgID != second {
+			t.Fatalf("unexpected latest messages %v %v", msgs, err)
+		}
+		if msgs, _ := store.GetMessagesBefore(alice, bob, second+1, 10); len(msgs) != 2 || msgs[0].MsgID != first || msgs[1].MsgID != second {
+			t.Fatalf("unexpected older messages %v", msgs)
+		}
+		if msgs, _ := store.GetMessagesBefore(carol, bob, 0, 10); len(msgs) != 0 {
+			t.Fatalf("messages shown to a third user %v", msgs)
+		}
+		msg, err := store.GetMessage(reply)
+		if err != nil || msg.ReplyTo != first || msg.RecipientID != alice || msg.Type != "image" || !reflect.DeepEqual(msg.Content, image) {
+			t.Fatalf("unexpected message %v %v", msg, err)
+		}
+		if _, err := store.GetMessage(missing); err != errorMessageDoesNotExist {
+			t.Fatalf("expected %v, got %v", errorMessageDoesNotExist, err)
+		}
+		byID, err := store.GetMessagesByID([]int{first, reply, missing})
+		if err != nil || len(byID) != 2 || !reflect.DeepEqual(byID[reply].Content, image) {
+			t.Fatalf("unexpected messages by id %v %v", byID, err)
+		}
+	})
+
+	step("receipts", func(t *testing.T) {
+		if err := store.MarkDelivered(bob, []int{first, reply}); err != nil {
+			t.Fatal(err)
+		}
+		if msg, _ := store.GetMessage(first); !msg.DeliveredAt.Valid || msg.ReadAt.Valid {
+			t.Fatalf("message not delivered %v", msg)
+		}
+		if msg, _ := store.GetMessage(reply); msg.DeliveredAt.Valid {
+			t.Fatalf("message delivered to its sender %v", msg)
+		}
+		conversations, err := store.GetConversations(bob, 10, 0)
+		if err != nil || len(conversations) != 1 || conversations[0].UserID != alice || conversations[0].Username != "alice"+suffix ||
+			conversations[0].LastMessage.MsgID != second || conversations[0].Unread != 2 {
+			t.Fatalf("unexpected conversations %v %v", conversations, err)
+		}
+		if n, err := store.MarkRead(bob, second, alice); err != nil || n != 2 {
+			t.Fatalf("expected 2 messages read, got %d %v", n, err)
+		}
+		if n, _ := store.MarkRead(bob, second, 0); n != 0 {
+			t.Fatalf("messages read twice %d", n)
+		}
+		if msg, _ := store.GetMessage(second); !msg.ReadAt.Valid || !msg.DeliveredAt.Valid {
+			t.Fatalf("message not read %v", msg)
+		}
+	})
+
+	step("conversations", func(t *testing.T) {
+		if conversations, _ := store.GetConversations(bob, 10, 0); len(conversations) != 1 || conversations[0].Unread != 0 {
+			t.Fatalf("unexpected conversations %v", conversations)
+		}
+		if conversations, _ := store.GetConversations(bob, 10, 1); len(conversations) != 0 {
+			t.Fatalf("offset not applied %v", conversations)
+		}
+		page, err := store.GetConversationMessages(alice, bob, 0, 0, 2)
+		if err != nil || len(page) != 2 || page[0].MsgID != reply || page[1].MsgID != second {
+			t.Fatalf("unexpected latest page %v %v", page, err)
+		}
+		if page, _ := store.GetConversationMessages(bob, alice, reply, 0, 2); len(page) != 1 || page[0].MsgID != first {
+			t.Fatalf("unexpected older page %v", page)
+		}
+		if page, _ := store.GetConversationMessages(alice, bob, 0, first, 1); len(page) != 1 || page[0].MsgID != reply {
+			t.Fatalf("unexpected newer page %v", page)
+		}
+		if page, _ := store.GetConversationMessages(alice, carol, 0, 0, 10); len(page) != 0 {
+			t.Fatalf("unexpected conversation %v", page)
+		}
+	})
+
+	step("edits", func(t *testing.T) {
+		edited := content.Content{Type: "text", Payload: content.Text{Text: "hello again bob"}}
+		if err := store.EditMessage(first, edited); err != nil {
+			t.Fatal(err)
+		}
+		if msg, _ := store.GetMessage(first); !reflect.DeepEqual(msg.Content, edited) || !msg.EditedAt.Valid {
+			t.Fatalf("message not edited %v", msg)
+		}
+		if err := store.EditMessage(reply, image); err != errorMessageTypeNotSupported {
+			t.Fatalf("expected %v, got %v", errorMessageTypeNotSupported, err)
+		}
+		lat, lon := 48.85, 2.35
+		pinned, _ := content.Validate(content.Content{Type: "location", Payload: content.Location{Latitude: &lat, Longitude: &lon}})
+		live, _ := content.Validate(content.Content{Type: "location", Payload: content.Location{Latitude: &lat, Longitude: &lon, Label: "here", LivePeriod: 600}})
+		pinnedID := send(t, Message{SenderID: alice, RecipientID: bob, Content: pinned})
+		liveID = send(t, Message{SenderID: alice, RecipientID: bob, Content: live})
+		movedLat, movedLon := 48.86, 2.34
+		moved, _ := content.Validate(content.Content{Type: "location", Payload: content.Location{Latitude: &movedLat, Longitude: &movedLon, Accuracy: 5}})
+		if err := store.EditMessage(pinnedID, moved); err == nil || err.Error() != content.ErrorLocationNotLive {
+			t.Fatalf("expected %s, got %v", content.ErrorLocationNotLive, err)
+		}
+		if err := store.EditMessage(liveID, moved); err != nil {
+			t.Fatal(err)
+		}
+		msg, _ := store.GetMessage(liveID)
+		loc, _ := msg.Content.Payload.(content.Location)
+		if loc.Latitude == nil || *loc.Latitude != movedLat || *loc.Longitude != movedLon || loc.Accuracy != 5 || loc.Label != "here" || loc.LiveUntil == "" {
+			t.Fatalf("location not moved %v", msg.Content)
+		}
+	})
+
+	step("reactions", func(t *testing.T) {
+		for _, reaction := range []struct {
+			uid   int
+			emoji string
+		}{{bob, "❤"}, {alice, "👍"}, {bob, "👍"}, {bob, "👍"}} {
+			if err := store.AddReaction(second, reaction.uid, reaction.emoji); err != nil {
+				t.Fatal(err)
+			}
+		}
+		reactions, err := store.GetReactions(alice, []int{first, second})
+		expected := []Reaction{{MsgID: second, Emoji: "👍", Count: 2, ReactedByMe: true}, {MsgID: second, Emoji: "❤", Count: 1}}
+		if err != nil || len(reactions) != 1 || !reflect.DeepEqual(reactions[second], expected) {
+			t.Fatalf("unexpected reactions %v %v", reactions, err)
+		}
+		if err := store.RemoveReaction(second, alice, "👍"); err != nil {
+			t.Fatal(err)
+		}
+		if reactions, _ := store.GetReactions(alice, []int{second}); len(reactions[second]) != 2 || reactions[second][0].Count != 1 || reactions[second][0].ReactedByMe {
+			t.Fatalf("reaction not removed %v", reactions)
+		}
+	})
+
+	step("thumbnails and link previews", func(t *testing.T) {
+		for _, thumbnail := range []Thumbnail{{reply, 1024, 1024, 512}, {reply, 256, 256, 128}, {reply, 256, 1, 1}} {
+			if err := store.AddThumbnail(thumbnail); err != nil {
+				t.Fatal(err)
+			}
+		}
+		thumbnails, err := store.GetThumbnails([]int{first, reply})
+		if err != nil || len(thumbnails) != 1 || !reflect.DeepEqual(thumbnails[reply], []Thumbnail{{reply, 256, 256, 128}, {reply, 1024, 1024, 512}}) {
+			t.Fatalf("unexpected thumbnails %v %v", thumbnails, err)
+		}
+		preview := LinkPreview{MsgID: first, Url: "https://example.com/a", Title: "A"}
+		if err := store.SetLinkPreview(preview); err != nil {
+			t.Fatal(err)
+		}
+		preview = LinkPreview{MsgID: first, Url: "https://example.com/b", Title: "B", Description: "b", ImageUrl: "https://example.com/b.png"}
+		if err := store.SetLinkPreview(preview); err != nil {
+			t.Fatal(err)
+		}
+		previews, err := store.GetLinkPreviews([]int{first, second})
+		if err != nil || len(previews) != 1 || previews[first] != preview {
+			t.Fatalf("unexpected link previews %v %v", previews, err)
+		}
+	})
+
+	step("deletes", func(t *testing.T) {
+		// deletes keep a tombstone without content, reactions, thumbnails or preview
+		for _, id := range []int{second, reply, first} {
+			if err := store.DeleteMessage(id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		msg, err := store.GetMessage(second)
+		if err != nil || msg.Type != "text" || !reflect.DeepEqual(msg.Content, content.Content{}) || !msg.DeletedAt.Valid {
+			t.Fatalf("unexpected tombstone %v %v", msg, err)
+		}
+		if reactions, _ := store.GetReactions(alice, []int{second}); len(reactions) != 0 {
+			t.Fatalf("reactions not deleted %v", reactions)
+		}
+		if thumbnails, _ := store.GetThumbnails([]int{reply}); len(thumbnails) != 0 {
+			t.Fatalf("thumbnails not deleted %v", thumbnails)
+		}
+		if previews, _ := store.GetLinkPreviews([]int{first}); len(previews) != 0 {
+			t.Fatalf("link preview not deleted %v", previews)
+		}
+	})
+
+	step("groups", func(t *testing.T) {
+		var err error
+		groupID, err = store.CreateGroup(alice, "friends", []int{bob})
+		if err != nil || groupID <= 0 {
+			t.Fatal("group not created", err)
+		}
+		if _, err := store.CreateGroup(alice, "strangers", []int{missing}); err != errUserDoesNotExist {
+			t.Fatalf("expected %v, got %v", errUserDoesNotExist, err)
+		}
+		group, err := store.GetGroup(groupID)
+		if err != nil || group.GroupID != groupID || group.Name != "friends" || group.OwnerID != alice || group.CreatedOn == "" {
+			t.Fatalf("unexpected group %v %v", group, err)
+		}
+		if _, err := store.GetGroup(missing); err != errGroupDoesNotExist {
+			t.Fatalf("expected %v, got %v", errGroupDoesNotExist, err)
+		}
+		if err := store.AddGroupMember(groupID, missing); err != errUserDoesNotExist {
+			t.Fatalf("expected %v, got %v", errUserDoesNotExist, err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := store.AddGroupMember(groupID, carol); err != nil {
+				t.Fatal(err)
+			}
+		}
+		members, err := store.GetGroupMembers(groupID)
+		if err != nil || len(members) != 3 || members[2].UserID != carol || members[2].Username != "carol"+suffix || members[2].JoinedOn == "" {
+			t.Fatalf("unexpected members %v %v", members, err)
+		}
+		groupMsg = send(t, Message{SenderID: bob, GroupID: groupID, Content: content.Content{Type: "text", Payload: content.Text{Text: "hi friends " + suffix}}})
+		if err := store.RemoveGroupMember(groupID, alice); err != errOwnerCannotLeave {
+			t.Fatalf("expected %v, got %v", errOwnerCannotLeave, err)
+		}
+		if member, _ := store.IsGroupMember(groupID, alice); !member {
+			t.Fatal("owner removed")
+		}
+		if err := store.RemoveGroupMember(groupID, carol); err != nil {
+			t.Fatal(err)
+		}
+		if member, err := store.IsGroupMember(groupID, carol); err != nil || member {
+			t.Fatal("member not removed", err)
+		}
+		if member, _ := store.IsGroupMember(groupID, bob); !member {
+			t.Fatal("member missing")
+		}
+		groupMsgs, err := store.GetGroupMessages(groupID, 0, 10)
+		if err != nil || len(groupMsgs) != 1 || groupMsgs[0].MsgID != groupMsg || groupMsgs[0].GroupID != groupID || groupMsgs[0].RecipientID != 0 {
+			t.Fatalf("unexpected group messages %v %v", groupMsgs, err)
+		}
+		received := func(uid int) []Message {
+			msgs, err := store.GetReceivedMessages(uid, groupMsg, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return msgs
+		}
+		if msgs := received(alice); len(msgs) != 1 || msgs[0].MsgID != groupMsg {
+			t.Fatalf("group message not received %v", msgs)
+		}
+		if len(received(bob)) != 0 || len(received(carol)) != 0 {
+			t.Fatal("group message received by its sender or a former member")
+		}
+		if msgs, _ := store.GetReceivedMessages(bob, 0, 10); len(msgs) == 0 || msgs[0].RecipientID != bob {
+			t.Fatalf("direct messages not received %v", msgs)
+		}
+		if conversations, _ := store.GetConversations(bob, 10, 0); len(conversations) != 1 || conversations[0].LastMessage.MsgID != liveID {
+			t.Fatalf("group message in conversations %v", conversations)
+		}
+	})
+
+	step("uploads", func(t *testing.T) {
+		uploadID := fmt.Sprintf("%x", time.Now().UnixNano())
+		upload := Upload{UploadID: uploadID, UserID: alice, MimeType: "image/png", Width: 2, Height: 1, Size: 70, Filename: "cat.png", Sha256: "ab"}
+		if err := store.CreateUpload(upload); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.CreateUpload(upload); err == nil {
+			t.Fatal("upload created twice")
+		}
+		stored, err := store.GetUpload(uploadID)
+		if err != nil || stored.CreatedOn == "" {
+			t.Fatalf("unexpected upload %v %v", stored, err)
+		}
+		stored.CreatedOn = ""
+		if stored != upload {
+			t.Fatalf("unexpected upload %v", stored)
+		}
+		if _, err := store.GetUpload("missing"); err != errorUploadDoesNotExist {
+			t.Fatalf("expected %v, got %v", errorUploadDoesNotExist, err)
+		}
+		canView := func(uid int) bool {
+			visible, err := store.CanViewUpload(uploadID, uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return visible
+		}
+		if !canView(alice) || canView(bob) || canView(carol) {
+			t.Fatal("upload visible before it is sent")
+		}
+		uploaded := content.Content{Type: "image", Payload: content.Image{Width: 2, Height: 1, Url: "/uploads/" + uploadID, UploadID: uploadID}}
+		send(t, Message{SenderID: alice, GroupID: groupID, Content: uploaded})
+		if !canView(bob) || canView(carol) {
+			t.Fatal("upload visible to the wrong users")
+		}
+	})
+
+	step("tokens", func(t *testing.T) {
+		jti := "jti" + suffix
+		if inserted, err := store.RevokeToken(jti, time.Now().Add(time.Hour)); err != nil || !inserted {
+			t.Fatal("token not newly revoked", err)
+		}
+		if inserted, err := store.RevokeToken(jti, time.Now().Add(time.Hour)); err != nil || inserted {
+			t.Fatal("token revoked twice", err)
+		}
+		if revoked, err := store.IsTokenRevoked(jti); err != nil || !revoked {
+			t.Fatal("token not revoked", err)
+		}
+		if revoked, _ := store.IsTokenRevoked("other" + suffix); revoked {
+			t.Fatal("unknown token revoked")
+		}
+	})
+
+	step("search", func(t *testing.T) {
+		results, err := store.SearchMessages(carol, "HI "+suffix, 10, 0)
+		if err != nil || len(results) != 0 {
+			t.Fatalf("search shows messages of a group left %v %v", results, err)
+		}
+		results, err = store.SearchMessages(bob, "HI "+suffix, 10, 0)
+		if err != nil || len(results) != 1 || results[0].Message.MsgID != groupMsg || results[0].Message.Content.Payload.(content.Text).Text != "hi friends "+suffix {
+			t.Fatalf("unexpected search results %v %v", results, err)
+		}
+		if results[0].Snippet != "<mark>hi</mark> friends <mark>"+suffix+"</mark>" {
+			t.Fatalf("unexpected snippet %s", results[0].Snippet)
+		}
+		if results, _ := store.SearchMessages(bob, "hello "+suffix, 10, 0); len(results) != 0 {
+			t.Fatalf("deleted messages found %v", results)
+		}
+	})
+}
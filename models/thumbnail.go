@@ -0,0 +1,64 @@
+package models
+
+import (
+	"log"
+	"strings"
+)
+
+// Thumbnail is a downscaled copy of the image of a message, Size is its longest side
+type Thumbnail struct {
+	MsgID  int
+	Size   int
+	Width  int
+	Height int
+}
+
+func (dao *DAO) AddThumbnail(thumbnail Thumbnail) error {
+	query := "INSERT INTO thumbnails (msg_id, size, width, height) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING"
+	_, err := dao.db.Exec(query, thumbnail.MsgID, thumbnail.Size, thumbnail.Width, thumbnail.Height)
+	if err != nil {
+		log.Println("error inserting thumbnail", err.Error())
+		return err
+	}
+	return nil
+}
+
+// get the thumbnails of msg_ids by message, smallest first
+func (dao *DAO) GetThumbnails(msg_ids []int) (map[int][]Thumbnail, error) {
+	thumbnails := make(map[int][]Thumbnail)
+	err := inChunks(msg_ids, func(ids []int) error {
+		query := `SELECT msg_id, size, width, height
+				  FROM thumbnails
+				  WHERE msg_id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)
+				  ORDER BY msg_id, size`
+		var args []interface{}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		res, err := dao.db.Query(query, args...)
+		if err != nil {
+			log.Println("error retrieving thumbnails", err.Error())
+			return err
+		}
+		defer res.Close()
+
+		for res.Next() {
+			var thumbnail Thumbnail
+			err := res.Scan(&thumbnail.MsgID, &thumbnail.Size, &thumbnail.Width, &thumbnail.Height)
+			if err != nil {
+				log.Println("error scanning thumbnails", err.Error())
+				return err
+			}
+			thumbnails[thumbnail.MsgID] = append(thumbnails[thumbnail.MsgID], thumbnail)
+		}
+		err = res.Err()
+		if err != nil {
+			log.Println("error occured during iteration", err.Error())
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return thumbnails, nil
+}
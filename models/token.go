@@ -0,0 +1,58 @@
+package models
+
+import (
+	"log"
+	"time"
+)
+
+// revoke a token by its jti, the entry is kept until the token expires. Returns false when
+// the token was already revoked, so a token can be exchanged only once
+func (dao *DAO) RevokeToken(jti string, expiresOn time.Time) (bool, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		log.Println("error starting Tx", err.Error())
+		return false, err
+	}
+
+	// expired tokens are rejected by the jwt middleware, no need to remember them
+	query := "DELETE FROM revoked_tokens WHERE expires_on < ?"
+	_, err = tx.Exec(query, time.Now().UTC())
+	if err != nil {
+		tx.Rollback()
+		log.Println("error removing expired revoked tokens", err.Error())
+		return false, err
+	}
+
+	query = "INSERT INTO revoked_tokens (jti, expires_on) VALUES (?, ?) ON CONFLICT DO NOTHING"
+	res, err := tx.Exec(query, jti, expiresOn.UTC())
+	if err != nil {
+		tx.Rollback()
+		log.Println("error inserting revoked token", err.Error())
+		return false, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		log.Println("error inserting revoked token", err.Error())
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("error committing revoked token", err.Error())
+		return false, err
+	}
+	return inserted == 1, nil
+}
+
+// check if token with jti has been revoked
+func (dao *DAO) IsTokenRevoked(jti string) (bool, error) {
+	var revoked bool
+	query := "SELECT EXISTS (SELECT jti FROM revoked_tokens WHERE jti = ?)"
+	err := dao.db.QueryRow(query, jti).Scan(&revoked)
+	if err != nil {
+		log.Println("error checking revoked token", err.Error())
+		return false, err
+	}
+	return revoked, nil
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/dtsang7/ASAPP/content"
+	"log"
+)
+
+// Upload is a file stored on disk by UploadID
+type Upload struct {
+	UploadID  string
+	UserID    int
+	MimeType  string
+	Width     int
+	Height    int
+	Size      int64
+	Filename  string
+	Sha256    string
+	CreatedOn string
+}
+
+const ErrorUploadDoesNotExist = "Upload does not exist"
+
+var errorUploadDoesNotExist = errors.New(ErrorUploadDoesNotExist)
+
+func (dao *DAO) CreateUpload(upload Upload) error {
+	query := "INSERT INTO uploads (upload_id, uid, mime_type, width, height, size, filename, sha256) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err := dao.db.Exec(query, upload.UploadID, upload.UserID, upload.MimeType, upload.Width, upload.Height, upload.Size, upload.Filename, upload.Sha256)
+	if err != nil {
+		log.Println("error inserting upload", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (dao *DAO) GetUpload(upload_id string) (Upload, error) {
+	var upload Upload
+	query := "SELECT upload_id, uid, mime_type, width, height, size, filename, sha256, created_on FROM uploads WHERE upload_id = ?"
+	err := dao.db.QueryRow(query, upload_id).Scan(&upload.UploadID, &upload.UserID, &upload.MimeType, &upload.Width, &upload.Height, &upload.Size, &upload.Filename, &upload.Sha256, content.RequiredTimestamp(&upload.CreatedOn))
+	if err == sql.ErrNoRows {
+		return upload, errorUploadDoesNotExist
+	}
+	if err != nil {
+		log.Println("error retrieving upload", err.Error())
+		return upload, err
+	}
+	return upload, nil
+}
+
+// whether uid uploaded the file or can see an image or file message sent from it that is not deleted
+func (dao *DAO) CanViewUpload(upload_id string, uid int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM uploads WHERE upload_id = ? AND uid = ?)
+			  OR EXISTS (SELECT 1 FROM messages
+			  	WHERE messages.msg_id IN (SELECT msg_id FROM images WHERE upload_id = ? UNION SELECT msg_id FROM files WHERE upload_id = ?)
+			  	AND messages.deleted_at IS NULL
+			  	AND (messages.sender_id = ? OR messages.recipient_id = ?
+			  		OR messages.group_id IN (SELECT group_id FROM group_members WHERE uid = ?)))`
+	var visible bool
+	err := dao.db.QueryRow(query, upload_id, uid, upload_id, upload_id, uid, uid, uid).Scan(&visible)
+	if err != nil {
+		log.Println("error checking upload access", err.Error())
+		return false, err
+	}
+	return visible, nil
+}
@@ -55,23 +55,16 @@ func (dao *DAO) CreateUser(usr User) (int, error) {
 		return 0, err
 	}
 
-	res, err := tx.Exec(query, username, string(hashedPassword))
+	//insert user and get its id
+	id, err := tx.insert(query, "uid", username, string(hashedPassword))
 	if err != nil {
 		tx.Rollback()
 		log.Println("error inserting user", err.Error())
 		return 0, err
 	}
 
-	//get user id
-	id, err := res.LastInsertId()
-	if err != nil {
-		tx.Rollback()
-		log.Println("error getting last inserted user id", err.Error())
-		return 0, err
-	}
-
 	tx.Commit()
-	return int(id), nil
+	return id, nil
 }
 
 //login user